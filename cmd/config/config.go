@@ -1,75 +1,285 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
+// defaultBaseURL - базовый адрес сокращённых URL по умолчанию;
+// при включённом HTTPS его схема меняется на https
+const defaultBaseURL = "http://localhost:8080"
+
 // Config содержит конфигурационные параметры приложения
 type Config struct {
-	ServerAddress   string
+	ServerAddress string
+	// GRPCAddress - адрес запуска gRPC-сервера, пустое значение отключает gRPC
+	GRPCAddress     string
 	BaseURL         string
 	FilePath        string
 	DatabaseAddress string
+	// FileCompactInterval - период сжатия журнала файлового хранилища, 0 отключает сжатие
+	FileCompactInterval time.Duration
+	// ShortCodeStrategy - способ формирования коротких кодов: hash, sequence, random или hashids
+	ShortCodeStrategy string
+	// ShortCodeLength - длина случайных кодов и минимальная длина кодов hashids
+	ShortCodeLength int
+	// ShortCodeAlphabet - алфавит кодов для стратегий sequence, random и hashids
+	ShortCodeAlphabet string
+	// ShortCodeSalt - соль для стратегии hashids
+	ShortCodeSalt string
+	// ExpirationSweepInterval - период пометки просроченных ссылок, 0 отключает фоновую пометку
+	ExpirationSweepInterval time.Duration
+	// DeleteGracePeriod - сколько удалённую ссылку можно восстановить до окончательной очистки
+	DeleteGracePeriod time.Duration
+	// PurgeInterval - период окончательной очистки удалённых ссылок, 0 отключает очистку
+	PurgeInterval time.Duration
+	// ShutdownTimeout - сколько ждать завершения обрабатываемых запросов при остановке сервера
+	ShutdownTimeout time.Duration
+	// EnableHTTPS включает HTTPS-сервер
+	EnableHTTPS bool
+	// TLSCertFile и TLSKeyFile - пути к сертификату и ключу в PEM;
+	// если оба пусты, при включённом HTTPS создаётся самоподписанный сертификат
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion - минимальная версия TLS: 1.2 или 1.3
+	TLSMinVersion string
+	// TLSCipherSuites - разрешённые наборы шифров TLS 1.2 через запятую, пусто - наборы Go по умолчанию
+	TLSCipherSuites string
+	// LogLevel - уровень логирования: debug, info, warn или error
+	LogLevel string
+	// TrustedSubnet - подсеть в нотации CIDR, из которой доступна статистика сервиса
+	TrustedSubnet string
+	// TrustedProxies - подсеть обратных прокси в нотации CIDR: только у запросов от них
+	// адрес клиента для статистики переходов берётся из X-Real-IP и X-Forwarded-For
+	TrustedProxies string
+	// SortQueryParams включает сортировку параметров запроса в сокращаемых URL,
+	// чтобы адреса, отличающиеся только их порядком, получали одну короткую ссылку
+	SortQueryParams bool
 }
 
-// parseFlags обрабатывает флаги командной строки и возвращает значения по умолчанию, если флаги не установлены
-func parseFlags() (string, string, string, string) {
-	serverAddressFlag := flag.String("a", "localhost:8080", "адрес запуска HTTP-сервера")
-	baseURLFlag := flag.String("b", "http://localhost:8080", "базовый адрес результирующего сокращённого URL")
-	filePathFlag := flag.String("f", "/tmp/short-url-db.json", "полное имя файла для сохранения данных в формате JSON")
-	databaseAddressFlag := flag.String("d", "", "Database address")
+// setting связывает параметр конфигурации с флагом, переменной окружения и ключом файла конфигурации.
+// Новый параметр достаточно объявить флагом в newFlagSet и добавить сюда.
+type setting struct {
+	flag string
+	env  string
+	key  string
+}
 
-	flag.Parse()
-	return *serverAddressFlag, *baseURLFlag, *filePathFlag, *databaseAddressFlag
+var settings = []setting{
+	{flag: "a", env: "SERVER_ADDRESS", key: "server_address"},
+	{flag: "g", env: "GRPC_ADDRESS", key: "grpc_address"},
+	{flag: "b", env: "BASE_URL", key: "base_url"},
+	{flag: "f", env: "FILE_STORAGE_PATH", key: "file_storage_path"},
+	{flag: "d", env: "DATABASE_DSN", key: "database_dsn"},
+	{flag: "compact-interval", env: "FILE_COMPACT_INTERVAL", key: "file_compact_interval"},
+	{flag: "code-strategy", env: "SHORT_CODE_STRATEGY", key: "short_code_strategy"},
+	{flag: "code-length", env: "SHORT_CODE_LENGTH", key: "short_code_length"},
+	{flag: "code-alphabet", env: "SHORT_CODE_ALPHABET", key: "short_code_alphabet"},
+	{flag: "code-salt", env: "SHORT_CODE_SALT", key: "short_code_salt"},
+	{flag: "expire-sweep-interval", env: "EXPIRATION_SWEEP_INTERVAL", key: "expiration_sweep_interval"},
+	{flag: "delete-grace", env: "DELETE_GRACE_PERIOD", key: "delete_grace_period"},
+	{flag: "purge-interval", env: "PURGE_INTERVAL", key: "purge_interval"},
+	{flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", key: "shutdown_timeout"},
+	{flag: "s", env: "ENABLE_HTTPS", key: "enable_https"},
+	{flag: "tls-cert", env: "TLS_CERT_FILE", key: "tls_cert_file"},
+	{flag: "tls-key", env: "TLS_KEY_FILE", key: "tls_key_file"},
+	{flag: "tls-min-version", env: "TLS_MIN_VERSION", key: "tls_min_version"},
+	{flag: "tls-ciphers", env: "TLS_CIPHER_SUITES", key: "tls_cipher_suites"},
+	{flag: "t", env: "TRUSTED_SUBNET", key: "trusted_subnet"},
+	{flag: "trusted-proxies", env: "TRUSTED_PROXIES", key: "trusted_proxies"},
+	{flag: "log-level", env: "LOG_LEVEL", key: "log_level"},
+	{flag: "sort-query", env: "SORT_QUERY_PARAMS", key: "sort_query_params"},
 }
 
-// parseEnv обрабатывает переменные окружения и возвращает их значения
-func parseEnv() (string, string, string, string) {
-	envServerAddress := os.Getenv("SERVER_ADDRESS")
-	envBaseURL := os.Getenv("BASE_URL")
-	envFilePath := os.Getenv("FILE_STORAGE_PATH")
-	envDatabaseAddress := os.Getenv("DATABASE_DSN")
-	return envServerAddress, envBaseURL, envFilePath, envDatabaseAddress
+// configFileEnv - переменная окружения с путём к файлу конфигурации
+const configFileEnv = "CONFIG"
+
+// newFlagSet объявляет флаги командной строки; значения по умолчанию записываются в cfg сразу
+func newFlagSet(cfg *Config, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(configFile, "c", "", "путь к файлу конфигурации в формате JSON")
+	fs.StringVar(&cfg.ServerAddress, "a", "localhost:8080", "адрес запуска HTTP-сервера")
+	fs.StringVar(&cfg.GRPCAddress, "g", "", "адрес запуска gRPC-сервера (по умолчанию gRPC не запускается)")
+	fs.StringVar(&cfg.BaseURL, "b", defaultBaseURL, "базовый адрес результирующего сокращённого URL")
+	fs.StringVar(&cfg.FilePath, "f", "/tmp/short-url-db.json", "полное имя файла для сохранения данных в формате JSON (пустое значение - хранить данные в памяти)")
+	fs.StringVar(&cfg.DatabaseAddress, "d", "", "Database address")
+	fs.DurationVar(&cfg.FileCompactInterval, "compact-interval", 10*time.Minute, "период сжатия журнала файлового хранилища (0 - не сжимать)")
+	fs.StringVar(&cfg.ShortCodeStrategy, "code-strategy", "hash", "способ формирования коротких кодов: hash, sequence, random или hashids")
+	fs.IntVar(&cfg.ShortCodeLength, "code-length", 8, "длина случайных кодов и минимальная длина кодов hashids")
+	fs.StringVar(&cfg.ShortCodeAlphabet, "code-alphabet", "", "алфавит коротких кодов (по умолчанию base62)")
+	fs.StringVar(&cfg.ShortCodeSalt, "code-salt", "", "соль для кодов hashids")
+	fs.DurationVar(&cfg.ExpirationSweepInterval, "expire-sweep-interval", time.Minute, "период пометки просроченных ссылок (0 - не помечать)")
+	fs.DurationVar(&cfg.DeleteGracePeriod, "delete-grace", 7*24*time.Hour, "срок, в течение которого удалённую ссылку можно восстановить")
+	fs.DurationVar(&cfg.PurgeInterval, "purge-interval", time.Hour, "период окончательной очистки удалённых ссылок (0 - не очищать)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "время на завершение обрабатываемых запросов при остановке сервера")
+	fs.BoolVar(&cfg.EnableHTTPS, "s", false, "включить HTTPS")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "путь к сертификату TLS в формате PEM")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "путь к закрытому ключу TLS в формате PEM")
+	fs.StringVar(&cfg.TLSMinVersion, "tls-min-version", "1.2", "минимальная версия TLS: 1.2 или 1.3")
+	fs.StringVar(&cfg.TLSCipherSuites, "tls-ciphers", "", "наборы шифров TLS 1.2 через запятую (по умолчанию наборы Go)")
+	fs.StringVar(&cfg.TrustedSubnet, "t", "", "доверенная подсеть (CIDR) для доступа к /api/internal/stats")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "подсеть (CIDR) обратных прокси, которым доверяется адрес клиента из заголовков")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "уровень логирования: debug, info, warn или error")
+	fs.BoolVar(&cfg.SortQueryParams, "sort-query", false, "сортировать параметры запроса в сокращаемых URL")
+	return fs
 }
 
-// NewConfig создает новый экземпляр конфигурации приложения на основе флагов командной строки и переменных окружения
-func NewConfig() *Config {
-	serverAddressFlag, baseURLFlag, filePathFlag, databaseAddressFlag := parseFlags()
-	envServerAddress, envBaseURL, envFilePath, envDatabaseAddress := parseEnv()
+// expected описывает ожидаемый формат значения флага для сообщений об ошибках
+func expected(f *flag.Flag) string {
+	switch f.Value.(flag.Getter).Get().(type) {
+	case int:
+		return "an integer"
+	case bool:
+		return "true or false"
+	case time.Duration:
+		return "a duration such as 30s or 10m"
+	default:
+		return "a string"
+	}
+}
 
-	cfg := &Config{}
+// parseEnv переопределяет значения конфигурации непустыми переменными окружения
+func parseEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	var errs []error
+	for _, s := range settings {
+		value := getenv(s.env)
+		if value == "" {
+			continue
+		}
+		if err := fs.Set(s.flag, value); err != nil {
+			errs = append(errs, fmt.Errorf("environment variable %s: invalid value %q, expected %s", s.env, value, expected(fs.Lookup(s.flag))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseFile переопределяет значения конфигурации значениями из JSON-файла.
+// Неизвестные ключи и значения неподходящего типа считаются ошибкой.
+func parseFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("config file %s: invalid JSON: %w", path, err)
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	if envServerAddress != "" {
-		cfg.ServerAddress = envServerAddress
-	} else {
-		cfg.ServerAddress = serverAddressFlag
+	var errs []error
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown key %q", path, key))
+			continue
+		}
+		f := fs.Lookup(s.flag)
+		value, err := fileValue(f, raw[key])
+		if err == nil {
+			err = fs.Set(s.flag, value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: key %q: invalid value %s, expected %s", path, key, raw[key], expected(f)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileValue переводит JSON-значение ключа в строку для flag.Value.Set,
+// проверяя, что тип JSON соответствует типу флага
+func fileValue(f *flag.Flag, raw json.RawMessage) (string, error) {
+	switch f.Value.(flag.Getter).Get().(type) {
+	case int:
+		var v int
+		err := json.Unmarshal(raw, &v)
+		return fmt.Sprint(v), err
+	case bool:
+		var v bool
+		err := json.Unmarshal(raw, &v)
+		return fmt.Sprint(v), err
+	default:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	}
+}
+
+// load собирает конфигурацию с приоритетом: флаги > переменные окружения > файл конфигурации > значения по умолчанию
+func load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := &Config{}
+	var configFile string
+	fs := newFlagSet(cfg, &configFile)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	if envBaseURL != "" {
-		cfg.BaseURL = envBaseURL
-	} else {
-		cfg.BaseURL = baseURLFlag
+	// Явно заданные флаги запоминаются, чтобы вернуть их поверх файла и окружения
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if configFile == "" {
+		configFile = getenv(configFileEnv)
+	}
+	if configFile != "" {
+		if err := parseFile(fs, configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := parseEnv(fs, getenv); err != nil {
+		return nil, err
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return nil, err
+		}
 	}
+	return cfg, nil
+}
 
-	if envFilePath != "" {
-		cfg.FilePath = envFilePath
-	} else {
-		cfg.FilePath = filePathFlag
+// NewConfig создает новый экземпляр конфигурации приложения из флагов командной строки,
+// переменных окружения и файла конфигурации и проверяет его
+func NewConfig() (*Config, error) {
+	cfg, err := load(os.Args[1:], os.Getenv)
+	if err != nil {
+		return nil, err
 	}
 
-	if envDatabaseAddress != "" {
-		cfg.DatabaseAddress = envDatabaseAddress
-	} else {
-		cfg.DatabaseAddress = databaseAddressFlag
+	if cfg.EnableHTTPS && cfg.BaseURL == defaultBaseURL {
+		cfg.BaseURL = "https://" + strings.TrimPrefix(defaultBaseURL, "http://")
 	}
 
-	cfg.ServerAddress = strings.TrimPrefix(cfg.ServerAddress, "http://")
-	parts := strings.Split(cfg.ServerAddress, ":")
-	if parts[0] == "" {
-		cfg.ServerAddress = "localhost:" + parts[1]
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
+	return cfg, nil
+}
 
-	return cfg
+// Changed возвращает имена полей конфигурации, значения которых в next отличаются от c
+func (c *Config) Changed(next *Config) []string {
+	var changed []string
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if a.Field(i).Interface() != b.Field(i).Interface() {
+			changed = append(changed, a.Type().Field(i).Name)
+		}
+	}
+	return changed
 }
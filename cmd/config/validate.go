@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/tlsutil"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// Validate проверяет конфигурацию и приводит адреса серверов к виду host:port.
+// Возвращает все найденные ошибки сразу, чтобы их можно было исправить за один запуск.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	address, err := normalizeAddress(c.ServerAddress)
+	if err == nil {
+		c.ServerAddress = address
+	}
+	check(err)
+	if c.GRPCAddress != "" {
+		grpcAddress, err := normalizeAddress(c.GRPCAddress)
+		if err == nil {
+			c.GRPCAddress = grpcAddress
+			if grpcAddress == c.ServerAddress {
+				err = fmt.Errorf("address %q is already used by the HTTP server", grpcAddress)
+			}
+		}
+		if err != nil {
+			check(fmt.Errorf("gRPC: %w", err))
+		}
+	}
+	check(validateBaseURL(c.BaseURL))
+	if c.DatabaseAddress != "" {
+		if _, err := pgx.ParseConfig(c.DatabaseAddress); err != nil {
+			check(fmt.Errorf("database DSN: %w", err))
+		}
+	} else if c.FilePath != "" {
+		check(checkWritable(c.FilePath))
+	}
+
+	if _, err := utils.NewCodeGenerator(c.ShortCodeStrategy, c.ShortCodeLength, c.ShortCodeAlphabet, c.ShortCodeSalt); err != nil {
+		check(fmt.Errorf("short codes: %w", err))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"file compact interval", c.FileCompactInterval},
+		{"expiration sweep interval", c.ExpirationSweepInterval},
+		{"delete grace period", c.DeleteGracePeriod},
+		{"purge interval", c.PurgeInterval},
+	} {
+		if d.value < 0 {
+			check(fmt.Errorf("%s must not be negative, got %s", d.name, d.value))
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		check(errors.New("shutdown timeout must be positive"))
+	}
+
+	if c.EnableHTTPS {
+		if _, err := tlsutil.NewConfig(c.TLSMinVersion, c.TLSCipherSuites); err != nil {
+			check(fmt.Errorf("TLS: %w", err))
+		}
+		if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+			check(errors.New("TLS: both certificate and key files must be set"))
+		}
+		for _, path := range []string{c.TLSCertFile, c.TLSKeyFile} {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				check(fmt.Errorf("TLS: %w", err))
+			}
+		}
+	}
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		check(fmt.Errorf("log level: %w", err))
+	}
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			check(fmt.Errorf("trusted subnet: %w", err))
+		}
+	}
+	if c.TrustedProxies != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedProxies); err != nil {
+			check(fmt.Errorf("trusted proxies: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// normalizeAddress приводит адрес сервера к виду host:port: отбрасывает схему
+// и подставляет localhost вместо пустого хоста. IPv6-адреса записываются в скобках.
+func normalizeAddress(address string) (string, error) {
+	address = strings.TrimPrefix(address, "http://")
+	address = strings.TrimPrefix(address, "https://")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("server address %q: %w", address, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("server address %q: invalid port %q", address, port)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// validateBaseURL проверяет, что базовый адрес - абсолютный http(s) URL без завершающего слеша
+func validateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("base URL %q: scheme must be http or https", baseURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("base URL %q: host is missing", baseURL)
+	}
+	if strings.HasSuffix(baseURL, "/") {
+		return fmt.Errorf("base URL %q: must not end with a slash", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("base URL %q: must not contain a query or fragment", baseURL)
+	}
+	return nil
+}
+
+// checkWritable проверяет, что в файл хранилища можно писать,
+// а если его ещё нет - что его можно создать в указанном каталоге
+func checkWritable(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+		return f.Close()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("file storage: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("file storage: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
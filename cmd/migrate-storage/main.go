@@ -0,0 +1,209 @@
+// Утилита migrate-storage переносит записи между файловым хранилищем и базой данных.
+//
+// Пример переноса из файла в Postgres:
+//
+//	migrate-storage -from file -to db -f /tmp/short-url-db.json -d postgres://...
+//
+// Записи выгружаются в порядке возрастания short_url, а последний перенесённый
+// short_url сохраняется в файл контрольной точки. Повторный запуск после
+// прерывания продолжает перенос с этой точки; после успешного завершения
+// контрольная точка удаляется.
+//
+// Переносятся ссылки вместе с историей правок. Переходы по ссылкам и собранная
+// по ним статистика не переносятся и в новом хранилище начинаются заново.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	_ "github.com/11Petrov/urlshortener/internal/migrations"
+	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/storage"
+	"github.com/11Petrov/urlshortener/internal/utils"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// checkpointEvery - через сколько перенесённых записей сохраняется контрольная точка
+const checkpointEvery = 100
+
+// options содержит параметры запуска утилиты
+type options struct {
+	From            string
+	To              string
+	FilePath        string
+	DatabaseAddress string
+	DryRun          bool
+	Checkpoint      string
+}
+
+// stats содержит итоги переноса
+type stats struct {
+	Read       int
+	Imported   int
+	Duplicates int
+}
+
+func parseOptions() (*options, error) {
+	opts := &options{}
+	flag.StringVar(&opts.From, "from", "file", "источник: file или db")
+	flag.StringVar(&opts.To, "to", "db", "приёмник: file или db")
+	flag.StringVar(&opts.FilePath, "f", os.Getenv("FILE_STORAGE_PATH"), "полное имя файла хранилища")
+	flag.StringVar(&opts.DatabaseAddress, "d", os.Getenv("DATABASE_DSN"), "Database address")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "только подсчитать записи, ничего не записывая")
+	flag.StringVar(&opts.Checkpoint, "checkpoint", "", "файл контрольной точки (по умолчанию <файл хранилища>.<from>-to-<to>.checkpoint)")
+	flag.Parse()
+
+	if opts.From == opts.To {
+		return nil, fmt.Errorf("source and destination are the same: %q", opts.From)
+	}
+	for _, kind := range []string{opts.From, opts.To} {
+		if kind != "file" && kind != "db" {
+			return nil, fmt.Errorf("unknown storage %q, want file or db", kind)
+		}
+	}
+	if opts.FilePath == "" {
+		return nil, errors.New("file storage path is not set (-f or FILE_STORAGE_PATH)")
+	}
+	if opts.DatabaseAddress == "" {
+		return nil, errors.New("database address is not set (-d or DATABASE_DSN)")
+	}
+	if opts.Checkpoint == "" {
+		opts.Checkpoint = fmt.Sprintf("%s.%s-to-%s.checkpoint", opts.FilePath, opts.From, opts.To)
+	}
+	return opts, nil
+}
+
+func main() {
+	log := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &log)
+
+	opts, err := parseOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := Run(ctx, opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openStore открывает хранилище указанного вида
+func openStore(ctx context.Context, kind string, opts *options) (storage.RecordStore, error) {
+	// Записи переносятся со своими короткими кодами, генератор нужен только для конструктора
+	gen := utils.HashGenerator{}
+	if kind == "db" {
+		return storage.NewDBStore(opts.DatabaseAddress, gen, ctx)
+	}
+	store, err := storage.NewRepoURL(opts.FilePath, 0, gen, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.(storage.RecordStore), nil
+}
+
+// Run переносит записи из opts.From в opts.To
+func Run(ctx context.Context, opts *options) error {
+	src, err := openStore(ctx, opts.From, opts)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer src.Close()
+	dst, err := openStore(ctx, opts.To, opts)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+	defer dst.Close()
+
+	_, err = migrate(ctx, src, dst, opts)
+	return err
+}
+
+// migrate переносит записи из src в dst, продолжая с контрольной точки opts.Checkpoint
+func migrate(ctx context.Context, src, dst storage.RecordStore, opts *options) (stats, error) {
+	log := logger.LoggerFromContext(ctx)
+
+	after, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return stats{}, fmt.Errorf("read checkpoint: %w", err)
+	}
+	if after != "" {
+		log.Infow("Resuming migration", "after short_url", after, "checkpoint", opts.Checkpoint)
+	}
+
+	var st stats
+	err = src.ExportRecords(ctx, after, func(e models.Event) error {
+		st.Read++
+
+		var duplicate bool
+		if opts.DryRun {
+			exists, err := dst.HasRecord(ctx, e)
+			if err != nil {
+				return fmt.Errorf("check %s: %w", e.ShortURL, err)
+			}
+			duplicate = exists
+		} else {
+			imported, err := dst.ImportRecord(ctx, e)
+			if err != nil {
+				return fmt.Errorf("import %s: %w", e.ShortURL, err)
+			}
+			duplicate = !imported
+		}
+
+		if duplicate {
+			st.Duplicates++
+		} else {
+			st.Imported++
+		}
+
+		if !opts.DryRun && st.Read%checkpointEvery == 0 {
+			return writeCheckpoint(opts.Checkpoint, e.ShortURL)
+		}
+		return nil
+	})
+	log.Infow(
+		"Migration finished",
+		"from", opts.From,
+		"to", opts.To,
+		"dry run", opts.DryRun,
+		"read", st.Read,
+		"imported", st.Imported,
+		"skipped duplicates", st.Duplicates,
+	)
+	if err != nil {
+		return st, err
+	}
+
+	if !opts.DryRun {
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return st, fmt.Errorf("remove checkpoint: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// readCheckpoint возвращает последний перенесённый short_url или пустую строку
+func readCheckpoint(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeCheckpoint атомарно сохраняет последний перенесённый short_url
+func writeCheckpoint(name, shortURL string) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(shortURL+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/storage"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testContext() context.Context {
+	testlog := logger.NewLogger()
+	return logger.ContextWithLogger(context.Background(), &testlog)
+}
+
+func newMemoryStore() storage.RecordStore {
+	return storage.NewMemoryStore(utils.HashGenerator{}).(storage.RecordStore)
+}
+
+func newFileStore(t *testing.T, ctx context.Context, path string) storage.RecordStore {
+	t.Helper()
+	store, err := storage.NewRepoURL(path, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	return store.(storage.RecordStore)
+}
+
+// exportAll возвращает все записи хранилища в порядке возрастания short_url
+func exportAll(t *testing.T, ctx context.Context, store storage.RecordStore) []models.Event {
+	t.Helper()
+	var events []models.Event
+	require.NoError(t, store.ExportRecords(ctx, "", func(e models.Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	return events
+}
+
+// fixtureEvents возвращает записи со всеми переносимыми полями в порядке возрастания short_url
+func fixtureEvents() []models.Event {
+	deletedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	clicksLeft := 3
+	return []models.Event{
+		{UserID: "user1", ShortURL: "aaa", OriginalURL: "https://practicum.yandex.ru/"},
+		{UserID: "user1", ShortURL: "bbb", OriginalURL: "https://yandex.ru/", DeletedFlag: true, DeletedAt: &deletedAt},
+		{UserID: "user2", ShortURL: "ccc", OriginalURL: "https://go.dev/", ExpiresAt: &expiresAt, ExpiredFlag: true},
+		{
+			UserID: "user2", ShortURL: "ddd", OriginalURL: "https://example.com/locked",
+			PasswordHash: "$2a$10$abcdefghijklmnopqrstuv", ClicksLeft: &clicksLeft,
+			Revisions: []models.Revision{{OriginalURL: "https://example.com/old", ChangedAt: deletedAt}},
+		},
+	}
+}
+
+// seed загружает записи в хранилище как есть
+func seed(t *testing.T, ctx context.Context, store storage.RecordStore, events []models.Event) {
+	t.Helper()
+	for _, e := range events {
+		imported, err := store.ImportRecord(ctx, e)
+		require.NoError(t, err)
+		require.True(t, imported)
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	ctx := testContext()
+	dir := t.TempDir()
+	events := fixtureEvents()
+
+	// память -> файл
+	src := newMemoryStore()
+	seed(t, ctx, src, events)
+	file := newFileStore(t, ctx, filepath.Join(dir, "db.json"))
+	st, err := migrate(ctx, src, file, &options{Checkpoint: filepath.Join(dir, "to-file.checkpoint")})
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: len(events), Imported: len(events)}, st)
+	require.NoError(t, file.Close())
+
+	// после переоткрытия файл содержит те же записи
+	file = newFileStore(t, ctx, filepath.Join(dir, "db.json"))
+	defer file.Close()
+	assert.Equal(t, events, exportAll(t, ctx, file))
+
+	// файл -> память
+	dst := newMemoryStore()
+	st, err = migrate(ctx, file, dst, &options{Checkpoint: filepath.Join(dir, "to-memory.checkpoint")})
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: len(events), Imported: len(events)}, st)
+	assert.Equal(t, events, exportAll(t, ctx, dst))
+}
+
+func TestMigrateDuplicates(t *testing.T) {
+	ctx := testContext()
+	opts := &options{Checkpoint: filepath.Join(t.TempDir(), "checkpoint")}
+	events := fixtureEvents()
+
+	src := newMemoryStore()
+	seed(t, ctx, src, events)
+	dst := newMemoryStore()
+	// в приёмнике уже есть другая ссылка на тот же оригинальный URL
+	seed(t, ctx, dst, []models.Event{{UserID: "user3", ShortURL: "zzz", OriginalURL: events[0].OriginalURL}})
+
+	st, err := migrate(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: len(events), Imported: len(events) - 1, Duplicates: 1}, st)
+
+	// повторный запуск не падает, а считает все записи дубликатами
+	st, err = migrate(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: len(events), Duplicates: len(events)}, st)
+	assert.Len(t, exportAll(t, ctx, dst), len(events))
+}
+
+// failingStore - приёмник, который перестаёт принимать записи после limit импортов
+type failingStore struct {
+	storage.RecordStore
+	limit int
+}
+
+var errStopped = errors.New("migration stopped")
+
+func (s *failingStore) ImportRecord(ctx context.Context, event models.Event) (bool, error) {
+	if s.limit == 0 {
+		return false, errStopped
+	}
+	s.limit--
+	return s.RecordStore.ImportRecord(ctx, event)
+}
+
+func TestMigrateResume(t *testing.T) {
+	ctx := testContext()
+	opts := &options{Checkpoint: filepath.Join(t.TempDir(), "checkpoint")}
+
+	const total = 250
+	src := newMemoryStore()
+	for i := 0; i < total; i++ {
+		seed(t, ctx, src, []models.Event{{
+			UserID:      "user1",
+			ShortURL:    fmt.Sprintf("code%03d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+		}})
+	}
+	dst := newMemoryStore()
+
+	// перенос обрывается на 151-й записи, контрольная точка сохранена после 100-й
+	_, err := migrate(ctx, src, &failingStore{RecordStore: dst, limit: 150}, opts)
+	require.ErrorIs(t, err, errStopped)
+	checkpoint, err := readCheckpoint(opts.Checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "code099", checkpoint)
+
+	// повторный запуск продолжает с контрольной точки; записи после неё,
+	// успевшие перенестись до обрыва, считаются дубликатами
+	st, err := migrate(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: total - checkpointEvery, Imported: 100, Duplicates: 50}, st)
+	assert.Len(t, exportAll(t, ctx, dst), total)
+
+	_, err = os.Stat(opts.Checkpoint)
+	assert.True(t, os.IsNotExist(err), "checkpoint must be removed after a successful run")
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	ctx := testContext()
+	dir := t.TempDir()
+	opts := &options{DryRun: true, Checkpoint: filepath.Join(dir, "checkpoint")}
+	events := fixtureEvents()
+
+	src := newMemoryStore()
+	seed(t, ctx, src, events)
+	dst := newFileStore(t, ctx, filepath.Join(dir, "db.json"))
+	defer dst.Close()
+	seed(t, ctx, dst, events[:1])
+
+	st, err := migrate(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, stats{Read: len(events), Imported: len(events) - 1, Duplicates: 1}, st)
+
+	assert.Equal(t, events[:1], exportAll(t, ctx, dst), "dry run must not write records")
+	_, err = os.Stat(opts.Checkpoint)
+	assert.True(t, os.IsNotExist(err), "dry run must not write a checkpoint")
+}
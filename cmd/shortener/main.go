@@ -2,51 +2,306 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/11Petrov/urlshortener/cmd/config"
+	"github.com/11Petrov/urlshortener/internal/analytics"
 	"github.com/11Petrov/urlshortener/internal/auth"
+	"github.com/11Petrov/urlshortener/internal/deleter"
+	"github.com/11Petrov/urlshortener/internal/grpcserver"
 	"github.com/11Petrov/urlshortener/internal/gzip"
 	"github.com/11Petrov/urlshortener/internal/handlers"
 	"github.com/11Petrov/urlshortener/internal/logger"
 	_ "github.com/11Petrov/urlshortener/internal/migrations"
 	"github.com/11Petrov/urlshortener/internal/storage"
+	"github.com/11Petrov/urlshortener/internal/subnet"
+	"github.com/11Petrov/urlshortener/internal/tlsutil"
+	"github.com/11Petrov/urlshortener/internal/utils"
 
 	"github.com/go-chi/chi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 func main() {
-	cfg := config.NewConfig()
 	log := logger.NewLogger()
+	cfg, err := config.NewConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		log.Fatal(err)
+	}
 
 	ctx := logger.ContextWithLogger(context.Background(), &log)
 
+	// Ненулевой код выхода означает, что сервер не запустился
+	// или остановился, не завершив работу корректно
 	if err := Run(cfg, ctx); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// Run запускает HTTP- и gRPC-серверы и работает до сигнала SIGINT, SIGTERM или SIGQUIT.
+// По SIGHUP конфигурация перечитывается без остановки серверов.
+// При остановке серверы дожидаются обрабатываемых запросов не дольше cfg.ShutdownTimeout,
+// затем фоновые обработчики дописывают свои очереди и хранилище закрывается.
 func Run(cfg *config.Config, ctx context.Context) error {
 	log := logger.LoggerFromContext(ctx)
-	storeURL := storage.NewRepo(cfg, ctx)
-	h := handlers.NewHandlerURL(storeURL, cfg.BaseURL)
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	trusted, err := subnet.NewTrusted(cfg.TrustedSubnet)
+	if err != nil {
+		return err
+	}
+	proxies, err := subnet.NewTrusted(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	var tlsCfg *tls.Config
+	if cfg.EnableHTTPS {
+		if tlsCfg, err = newTLSConfig(cfg, ctx); err != nil {
+			return err
+		}
+	}
+	var grpcListener net.Listener
+	if cfg.GRPCAddress != "" {
+		if grpcListener, err = net.Listen("tcp", cfg.GRPCAddress); err != nil {
+			return fmt.Errorf("listen gRPC: %w", err)
+		}
+		defer grpcListener.Close()
+	}
+
+	// Фоновые обработчики останавливаются только после сервера,
+	// чтобы дописать работу, поставленную в очередь последними запросами
+	workersCtx, stopWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	runWorker := func(fn func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			fn(workersCtx)
+		}()
+	}
+
+	storeURL := storage.NewRepo(cfg, workersCtx)
+	if cfg.ExpirationSweepInterval > 0 {
+		runWorker(func(ctx context.Context) {
+			storage.RunExpirationSweeper(ctx, storeURL, cfg.ExpirationSweepInterval)
+		})
+	}
+	if cfg.PurgeInterval > 0 {
+		runWorker(func(ctx context.Context) {
+			storage.RunPurger(ctx, storeURL, cfg.PurgeInterval, cfg.DeleteGracePeriod)
+		})
+	}
+	clicks := analytics.NewRecorder(storeURL, analytics.DefaultBufferSize)
+	runWorker(clicks.Run)
+	deletes := deleter.NewService(storeURL, deleter.DefaultBufferSize, deleter.DefaultWorkers)
+	runWorker(deletes.Run)
+
+	urls := utils.URLNormalizer{SortQuery: cfg.SortQueryParams}
+	// Один лимитер на HTTP и gRPC, чтобы попытки подобрать пароль к ссылке считались вместе
+	attempts := utils.NewAttemptLimiter(utils.MaxPasswordAttempts, utils.PasswordAttemptsWindow)
+	h := handlers.NewHandlerURL(storeURL, cfg.BaseURL, clicks, deletes, cfg.DeleteGracePeriod, urls, attempts, proxies)
 	r := chi.NewRouter()
 	r.Use(logger.WithLogging)
 	r.Use(auth.AuthMiddleware)
 
 	r.Post("/", gzip.GzipMiddleware(h.ShortenURL))
 	r.Get("/{id}", gzip.GzipMiddleware(h.RedirectURL))
+	r.Post("/{id}", gzip.GzipMiddleware(h.RedirectURL))
 	r.Post("/api/shorten", gzip.GzipMiddleware(h.JSONShortenURL))
 	r.Get("/ping", h.Ping)
 	r.Post("/api/shorten/batch", gzip.GzipMiddleware(h.BatchShortenURL))
 	r.Get("/api/user/urls", gzip.GzipMiddleware(h.GetUserURLs))
+	r.Get("/api/user/urls/{id}/stats", gzip.GzipMiddleware(h.GetURLStats))
+	r.Patch("/api/user/urls/{id}", gzip.GzipMiddleware(h.UpdateURL))
 	r.Delete("/api/user/urls", gzip.GzipMiddleware(h.DeleteUserURLs))
-	log.Infow(
-		"Running server",
-		"address", cfg.ServerAddress,
-		"DSN", cfg.DatabaseAddress,
-	)
-	return http.ListenAndServe(cfg.ServerAddress, r)
+	r.Post("/api/user/urls/restore", gzip.GzipMiddleware(h.RestoreUserURLs))
+	r.Get("/api/internal/stats", trusted.Middleware(h.GetServiceStats))
+	r.Get("/debug/vars", trusted.PeerMiddleware(deleter.MetricsHandler))
+
+	g := grpcserver.NewServer(storeURL, cfg.BaseURL, clicks, deletes, trusted, proxies, urls, attempts)
+	go watchReload(ctx, hup, cfg, h, g, trusted)
+
+	srv := &http.Server{
+		Addr:      cfg.ServerAddress,
+		Handler:   r,
+		TLSConfig: tlsCfg,
+	}
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Infow(
+			"Running server",
+			"address", cfg.ServerAddress,
+			"https", cfg.EnableHTTPS,
+			"DSN", cfg.DatabaseAddress,
+		)
+		if cfg.EnableHTTPS {
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var grpcSrv *grpc.Server
+	if grpcListener != nil {
+		var opts []grpc.ServerOption
+		if tlsCfg != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		}
+		grpcSrv = grpcserver.NewGRPCServer(ctx, g, opts...)
+		go func() {
+			log.Infow("Running gRPC server", "address", cfg.GRPCAddress, "tls", tlsCfg != nil)
+			if err := grpcSrv.Serve(grpcListener); err != nil {
+				serveErr <- fmt.Errorf("gRPC: %w", err)
+			}
+		}()
+	}
+
+	var errs []error
+	select {
+	case err := <-serveErr:
+		errs = append(errs, fmt.Errorf("serve: %w", err))
+		srv.Close()
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+	case <-ctx.Done():
+		log.Infow("Shutting down server", "timeout", cfg.ShutdownTimeout.String())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if grpcSrv != nil {
+			go func() {
+				// GracefulStop ждёт незавершённые вызовы без ограничения по времени
+				<-shutdownCtx.Done()
+				grpcSrv.Stop()
+			}()
+		}
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown server: %w", err))
+		}
+		if grpcSrv != nil {
+			grpcSrv.GracefulStop()
+			if shutdownCtx.Err() != nil {
+				errs = append(errs, errors.New("shutdown gRPC server: timeout exceeded"))
+			}
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+	if err := storeURL.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close storage: %w", err))
+	}
+	if len(errs) == 0 {
+		log.Info("Server stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// newTLSConfig собирает настройки TLS из конфигурации. Если пути к сертификату
+// и ключу не заданы, для разработки создаётся самоподписанный сертификат.
+func newTLSConfig(cfg *config.Config, ctx context.Context) (*tls.Config, error) {
+	log := logger.LoggerFromContext(ctx)
+	tlsCfg, err := tlsutil.NewConfig(cfg.TLSMinVersion, cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	// Config.Validate гарантирует, что сертификат и ключ заданы вместе
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+		return tlsCfg, nil
+	}
+
+	host, _, err := net.SplitHostPort(cfg.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tlsutil.SelfSigned([]string{host, "localhost", "127.0.0.1", "::1"})
+	if err != nil {
+		return nil, fmt.Errorf("generate self-signed certificate: %w", err)
+	}
+	log.Warnw("TLS certificate is not configured, using a self-signed certificate", "host", host)
+	tlsCfg.Certificates = []tls.Certificate{cert}
+	return tlsCfg, nil
+}
+
+// reloadable - поля конфигурации, которые применяются по SIGHUP без перезапуска
+var reloadable = map[string]bool{
+	"LogLevel":      true,
+	"BaseURL":       true,
+	"TrustedSubnet": true,
+}
+
+// watchReload по каждому сигналу из hup перечитывает конфигурацию из тех же
+// источников, что и при запуске, и применяет к работающим компонентам изменившиеся
+// поля из reloadable. Об остальных изменениях пишет в лог, что нужен перезапуск.
+// Если новая конфигурация не прошла проверку, остаётся действующая.
+func watchReload(ctx context.Context, hup <-chan os.Signal, cfg *config.Config, h *handlers.HandlerURL, g *grpcserver.Server, trusted *subnet.Trusted) {
+	log := logger.LoggerFromContext(ctx)
+	current := *cfg
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		next, err := config.NewConfig()
+		if err != nil {
+			log.Errorw("Configuration reload failed, keeping current settings", "error", err)
+			continue
+		}
+
+		var applied, restart []string
+		for _, name := range current.Changed(next) {
+			if reloadable[name] {
+				applied = append(applied, name)
+			} else {
+				restart = append(restart, name)
+			}
+		}
+		// Новая конфигурация уже проверена, поэтому применение не может завершиться ошибкой
+		if err := trusted.Set(next.TrustedSubnet); err != nil {
+			log.Errorf("error set trusted subnet %s", err)
+		}
+		h.SetBaseURL(next.BaseURL)
+		g.SetBaseURL(next.BaseURL)
+		log.Infow(
+			"Configuration reloaded",
+			"applied", applied,
+			"restart required", restart,
+		)
+		// Уровень меняется последним, чтобы итог перезагрузки попал в лог при прежнем уровне
+		if err := logger.SetLevel(next.LogLevel); err != nil {
+			log.Errorf("error SetLevel %s", err)
+		}
+		current.LogLevel, current.BaseURL, current.TrustedSubnet = next.LogLevel, next.BaseURL, next.TrustedSubnet
+	}
 }
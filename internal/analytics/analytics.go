@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/subnet"
+)
+
+const (
+	// DefaultBufferSize - размер очереди переходов, ожидающих записи
+	DefaultBufferSize = 1024
+	// batchSize - сколько переходов записывается за один вызов хранилища
+	batchSize = 100
+	// flushInterval - как часто записываются неполные пакеты
+	flushInterval = time.Second
+)
+
+// ClickStore - хранилище, в которое записываются переходы
+type ClickStore interface {
+	RecordClicks(ctx context.Context, clicks []models.Click) error
+}
+
+// Recorder асинхронно записывает переходы по ссылкам. Record не блокирует
+// редирект: переход кладётся в буферизованный канал, а фоновый обработчик
+// пишет их в хранилище пакетами. Если очередь переполнена, переход
+// отбрасывается и учитывается в Dropped.
+type Recorder struct {
+	store   ClickStore
+	queue   chan models.Click
+	dropped atomic.Int64
+}
+
+// NewRecorder создает Recorder с очередью на bufferSize переходов
+func NewRecorder(store ClickStore, bufferSize int) *Recorder {
+	return &Recorder{
+		store: store,
+		queue: make(chan models.Click, bufferSize),
+	}
+}
+
+// Record ставит переход в очередь на запись
+func (r *Recorder) Record(click models.Click) {
+	select {
+	case r.queue <- click:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped возвращает число переходов, отброшенных из-за переполнения очереди
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Run записывает переходы из очереди, пока не отменён ctx, после чего
+// дописывает оставшиеся в очереди переходы и возвращается
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, batchSize)
+	for {
+		select {
+		case click := <-r.queue:
+			batch = append(batch, click)
+			if len(batch) >= batchSize {
+				batch = r.flush(ctx, batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(ctx, batch)
+		case <-ctx.Done():
+			// ctx уже отменён, поэтому остаток пишется с новым контекстом
+			flushCtx := logger.ContextWithLogger(context.Background(), logger.LoggerFromContext(ctx))
+			for {
+				select {
+				case click := <-r.queue:
+					batch = append(batch, click)
+					if len(batch) >= batchSize {
+						batch = r.flush(flushCtx, batch)
+					}
+				default:
+					r.flush(flushCtx, batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush записывает пакет в хранилище и возвращает пустой пакет для повторного использования
+func (r *Recorder) flush(ctx context.Context, batch []models.Click) []models.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	log := logger.LoggerFromContext(ctx)
+	if err := r.store.RecordClicks(ctx, batch); err != nil {
+		log.Errorf("error RecordClicks %s", err)
+	}
+	return batch[:0]
+}
+
+// NewClick описывает переход по ссылке shortURL из запроса r; адрес клиента
+// определяется через ClientIP с доверенными прокси proxies
+func NewClick(r *http.Request, shortURL string, proxies *subnet.Trusted) models.Click {
+	return models.Click{
+		ShortURL:  shortURL,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        TruncateIP(ClientIP(r, proxies)),
+	}
+}
+
+// ClientIP возвращает адрес клиента. X-Real-IP и X-Forwarded-For учитываются,
+// только если соединение пришло из доверенной подсети proxies: иначе любой клиент
+// мог бы подставить произвольный адрес. В остальных случаях берётся адрес соединения.
+func ClientIP(r *http.Request, proxies *subnet.Trusted) string {
+	peer := subnet.PeerIP(r)
+	if proxies == nil || !proxies.ContainsIP(peer) {
+		return peer
+	}
+	if ip := r.Header.Get(subnet.RealIPHeader); ip != "" {
+		return strings.TrimSpace(ip)
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		ip, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(ip)
+	}
+	return peer
+}
+
+// TruncateIP обнуляет младшие биты адреса, чтобы не хранить адрес клиента целиком:
+// у IPv4 остаётся сеть /24, у IPv6 - сеть /48
+func TruncateIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,44 @@
+package analytics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/11Petrov/urlshortener/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := subnet.NewTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		proxies   *subnet.Trusted
+		peer      string
+		realIP    string
+		forwarded string
+		want      string
+	}{
+		{name: "no headers", proxies: proxies, peer: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "trusted proxy real ip", proxies: proxies, peer: "10.1.2.3:5000", realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted proxy forwarded", proxies: proxies, peer: "10.1.2.3:5000", forwarded: "198.51.100.2, 10.1.2.3", want: "198.51.100.2"},
+		{name: "untrusted peer real ip", proxies: proxies, peer: "203.0.113.7:5000", realIP: "198.51.100.1", want: "203.0.113.7"},
+		{name: "untrusted peer forwarded", proxies: proxies, peer: "203.0.113.7:5000", forwarded: "198.51.100.2", want: "203.0.113.7"},
+		{name: "no trusted subnet", peer: "10.1.2.3:5000", realIP: "198.51.100.1", want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.RemoteAddr = tt.peer
+			if tt.realIP != "" {
+				r.Header.Set(subnet.RealIPHeader, tt.realIP)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			assert.Equal(t, tt.want, ClientIP(r, tt.proxies))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+)
+
+const (
+	// DefaultBufferSize - размер очереди запросов на удаление
+	DefaultBufferSize = 1024
+	// DefaultWorkers - число обработчиков, собирающих запросы в пакеты
+	DefaultWorkers = 4
+	// batchSize - сколько коротких URL набирает обработчик, прежде чем записать пакет
+	batchSize = 500
+	// flushInterval - как часто записываются неполные пакеты
+	flushInterval = time.Second
+)
+
+// ErrQueueFull возвращается, если очередь удаления переполнена
+var ErrQueueFull = errors.New("delete queue is full")
+
+// metrics отдаётся обработчиком MetricsHandler
+var (
+	metrics      = expvar.NewMap("deleter")
+	queueDepth   = new(expvar.Int)
+	deletedURLs  = new(expvar.Int)
+	flushes      = new(expvar.Int)
+	flushErrors  = new(expvar.Int)
+	rejectedReqs = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("queue_depth", queueDepth)
+	metrics.Set("deleted_urls", deletedURLs)
+	metrics.Set("flushes", flushes)
+	metrics.Set("flush_errors", flushErrors)
+	metrics.Set("rejected_requests", rejectedReqs)
+}
+
+// MetricsHandler отдаёт метрики удаления в формате expvar: {"deleter": {...}}.
+// Остальные переменные expvar, например cmdline с параметрами запуска и DSN базы, не отдаются.
+func MetricsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(rw, "{%q: %s}\n", "deleter", metrics.String())
+}
+
+// DeleteStore - хранилище, в котором помечаются удалёнными URL пользователя.
+// DeleteUserURLs возвращает число действительно помеченных ссылок: чужие,
+// несуществующие и уже удалённые не считаются.
+type DeleteStore interface {
+	DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error)
+}
+
+// request - запрос пользователя на удаление его URL
+type request struct {
+	userID    string
+	shortURLs []string
+}
+
+// Service асинхронно удаляет URL пользователей. Enqueue кладёт запрос в
+// буферизованный канал и сразу возвращается, а пул обработчиков собирает
+// запросы в пакеты по пользователям и пишет их в хранилище по размеру
+// пакета или по таймеру.
+type Service struct {
+	store   DeleteStore
+	queue   chan request
+	workers int
+}
+
+// NewService создает Service с очередью на bufferSize запросов и workers обработчиками
+func NewService(store DeleteStore, bufferSize, workers int) *Service {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Service{
+		store:   store,
+		queue:   make(chan request, bufferSize),
+		workers: workers,
+	}
+}
+
+// Enqueue ставит удаление URL пользователя в очередь.
+// Если очередь переполнена, возвращает ErrQueueFull.
+func (s *Service) Enqueue(userID string, shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	select {
+	case s.queue <- request{userID: userID, shortURLs: shortURLs}:
+		queueDepth.Add(1)
+		return nil
+	default:
+		rejectedReqs.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// QueueDepth возвращает число запросов, ожидающих обработки
+func (s *Service) QueueDepth() int {
+	return len(s.queue)
+}
+
+// Run обрабатывает очередь, пока не отменён ctx, после чего обработчики
+// дописывают оставшиеся в очереди запросы. Run возвращается, когда очередь разобрана.
+func (s *Service) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// batch - набранные обработчиком короткие URL, сгруппированные по пользователю
+type batch struct {
+	urls  map[string][]string
+	count int
+}
+
+func (b *batch) add(req request) {
+	if b.urls == nil {
+		b.urls = make(map[string][]string)
+	}
+	b.urls[req.userID] = append(b.urls[req.userID], req.shortURLs...)
+	b.count += len(req.shortURLs)
+}
+
+// work собирает запросы из очереди в пакет и записывает его
+func (s *Service) work(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var b batch
+	for {
+		select {
+		case req := <-s.queue:
+			queueDepth.Add(-1)
+			b.add(req)
+			if b.count >= batchSize {
+				s.flush(ctx, &b)
+			}
+		case <-ticker.C:
+			s.flush(ctx, &b)
+		case <-ctx.Done():
+			// ctx уже отменён, поэтому остаток пишется с новым контекстом
+			flushCtx := logger.ContextWithLogger(context.Background(), logger.LoggerFromContext(ctx))
+			for {
+				select {
+				case req := <-s.queue:
+					queueDepth.Add(-1)
+					b.add(req)
+					if b.count >= batchSize {
+						s.flush(flushCtx, &b)
+					}
+				default:
+					s.flush(flushCtx, &b)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush помечает удалёнными URL из пакета одним обращением к хранилищу на пользователя
+// и очищает пакет
+func (s *Service) flush(ctx context.Context, b *batch) {
+	if b.count == 0 {
+		return
+	}
+	log := logger.LoggerFromContext(ctx)
+	for userID, urls := range b.urls {
+		deleted, err := s.store.DeleteUserURLs(ctx, userID, urls)
+		if err != nil {
+			flushErrors.Add(1)
+			log.Errorf("error DeleteUserURLs %s", err)
+			continue
+		}
+		deletedURLs.Add(int64(deleted))
+	}
+	flushes.Add(1)
+	b.urls = nil
+	b.count = 0
+}
@@ -0,0 +1,92 @@
+package deleter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingStore struct {
+	mu      sync.Mutex
+	calls   int
+	deleted map[string][]string
+}
+
+// DeleteUserURLs запоминает удалённые URL; уже удалённые повторно не считаются
+func (s *recordingStore) DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	deleted := 0
+	for _, url := range urls {
+		if !slices.Contains(s.deleted[userID], url) {
+			s.deleted[userID] = append(s.deleted[userID], url)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestServiceDrainsOnShutdown(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx, cancel := context.WithCancel(logger.ContextWithLogger(context.Background(), &testlog))
+
+	store := &recordingStore{deleted: make(map[string][]string)}
+	s := NewService(store, 16, 2)
+	require.NoError(t, s.Enqueue("user1", []string{"a", "b"}))
+	require.NoError(t, s.Enqueue("user2", []string{"c"}))
+	require.NoError(t, s.Enqueue("user1", []string{"d"}))
+	require.NoError(t, s.Enqueue("user1", nil))
+
+	// обработчики запускаются после отмены и должны разобрать всю очередь
+	cancel()
+	s.Run(ctx)
+
+	assert.Equal(t, 0, s.QueueDepth())
+	assert.ElementsMatch(t, []string{"a", "b", "d"}, store.deleted["user1"])
+	assert.Equal(t, []string{"c"}, store.deleted["user2"])
+	assert.LessOrEqual(t, store.calls, 3)
+}
+
+func TestServiceQueueFull(t *testing.T) {
+	s := NewService(&recordingStore{deleted: make(map[string][]string)}, 1, 1)
+	require.NoError(t, s.Enqueue("user1", []string{"a"}))
+	assert.ErrorIs(t, s.Enqueue("user1", []string{"b"}), ErrQueueFull)
+}
+
+func TestServiceCountsDeletedURLs(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx, cancel := context.WithCancel(logger.ContextWithLogger(context.Background(), &testlog))
+
+	store := &recordingStore{deleted: map[string][]string{"user1": {"a"}}}
+	s := NewService(store, 16, 1)
+	require.NoError(t, s.Enqueue("user1", []string{"a", "b"}))
+	require.NoError(t, s.Enqueue("user1", []string{"b", "c"}))
+
+	before := deletedURLs.Value()
+	cancel()
+	s.Run(ctx)
+
+	// "a" был удалён раньше, а "b" запрошен дважды: метрика считает только новые удаления
+	assert.Equal(t, int64(2), deletedURLs.Value()-before)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var vars map[string]map[string]int64
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vars))
+	// отдаются только метрики удаления, без cmdline и memstats
+	require.Len(t, vars, 1)
+	assert.Contains(t, vars["deleter"], "deleted_urls")
+	assert.Contains(t, vars["deleter"], "queue_depth")
+}
@@ -0,0 +1,83 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/auth"
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AuthMetadataKey - ключ метаданных с JWT пользователя в виде "Bearer <token>";
+	// под этим же ключом сервер возвращает новый токен в заголовке ответа
+	AuthMetadataKey = "authorization"
+	// RealIPMetadataKey - ключ метаданных с IP-адресом клиента
+	RealIPMetadataKey = "x-real-ip"
+
+	bearerPrefix = "Bearer "
+)
+
+// LoggingInterceptor добавляет log в контекст запроса и пишет в лог метод, код ответа и длительность
+func LoggingInterceptor(log *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(logger.ContextWithLogger(ctx, log), req)
+
+		log.Infoln(
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start),
+		)
+		return resp, err
+	}
+}
+
+// AuthInterceptor определяет пользователя по JWT из метаданных authorization,
+// как auth.AuthMiddleware делает это по cookie. Если токена нет или он недействителен,
+// пользователю выдаётся новый идентификатор, а токен отправляется в заголовке ответа.
+func AuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	log := logger.LoggerFromContext(ctx)
+
+	var userID string
+	if token := tokenFromMetadata(ctx); token != "" {
+		if id, err := auth.GetUserID(ctx, token); err == nil && id != "" {
+			userID = id
+		}
+	}
+	if userID == "" {
+		userID = uuid.New().String()
+		token, err := auth.BuildJWTString(ctx, userID)
+		if err != nil {
+			log.Errorf("AuthInterceptor BuildJWTString err = %s", err)
+		} else if err := grpc.SetHeader(ctx, metadata.Pairs(AuthMetadataKey, bearerPrefix+token)); err != nil {
+			log.Errorf("AuthInterceptor SetHeader err = %s", err)
+		}
+	}
+
+	return handler(context.WithValue(ctx, auth.UserIDKey, userID), req)
+}
+
+// tokenFromMetadata возвращает JWT из входящих метаданных или пустую строку
+func tokenFromMetadata(ctx context.Context) string {
+	return strings.TrimPrefix(firstMetadata(ctx, AuthMetadataKey), bearerPrefix)
+}
+
+// firstMetadata возвращает первое значение ключа входящих метаданных
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
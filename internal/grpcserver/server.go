@@ -0,0 +1,363 @@
+// Package grpcserver реализует gRPC API сервиса из proto/shortener.proto
+// поверх того же хранилища, что и HTTP-обработчики.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/analytics"
+	"github.com/11Petrov/urlshortener/internal/auth"
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/subnet"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	pb "github.com/11Petrov/urlshortener/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serverURLStore определяет приватный интерфейс для хранилища URL
+type serverURLStore interface {
+	ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error)
+	RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error)
+	Ping(ctx context.Context) error
+	BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error)
+	GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error)
+	DeleteUserURLs(ctx context.Context, userID string, shortURL []string) (int, error)
+	GetServiceStats(ctx context.Context) (models.ServiceStats, error)
+}
+
+// clickRecorder принимает переходы по ссылкам для статистики
+type clickRecorder interface {
+	Record(click models.Click)
+}
+
+// deleteQueue принимает запросы на асинхронное удаление URL пользователя
+type deleteQueue interface {
+	Enqueue(userID string, shortURLs []string) error
+}
+
+// Server обрабатывает gRPC-запросы
+type Server struct {
+	pb.UnimplementedShortenerServer
+
+	storeURL         serverURLStore
+	baseURL          atomic.Pointer[string]
+	passwordAttempts *utils.AttemptLimiter
+	clicks           clickRecorder
+	deletes          deleteQueue
+	trusted          *subnet.Trusted
+	// proxies - подсеть прокси, которым доверяется адрес клиента из метаданных x-real-ip
+	proxies *subnet.Trusted
+	// urls проверяет сокращаемые URL и приводит их к каноническому виду
+	urls utils.URLNormalizer
+}
+
+// NewServer создает новый экземпляр Server.
+// Если clicks равен nil, переходы не записываются.
+// Если deletes равен nil, URL удаляются синхронно в рамках запроса.
+// Лимитер попыток пароля attempts общий с HTTP API; если он равен nil, создаётся собственный.
+// Статистика сервиса доступна из подсети trusted, а адрес клиента для статистики переходов
+// берётся из метаданных только у соединений из подсети proxies.
+func NewServer(storeURL serverURLStore, baseURL string, clicks clickRecorder, deletes deleteQueue, trusted, proxies *subnet.Trusted, urls utils.URLNormalizer, attempts *utils.AttemptLimiter) *Server {
+	if attempts == nil {
+		attempts = utils.NewAttemptLimiter(utils.MaxPasswordAttempts, utils.PasswordAttemptsWindow)
+	}
+	s := &Server{
+		storeURL:         storeURL,
+		passwordAttempts: attempts,
+		clicks:           clicks,
+		deletes:          deletes,
+		trusted:          trusted,
+		proxies:          proxies,
+		urls:             urls,
+	}
+	s.SetBaseURL(baseURL)
+	return s
+}
+
+// NewGRPCServer создает gRPC-сервер с зарегистрированным Server и перехватчиками
+// логирования и авторизации; запросы пишутся в логгер из ctx
+func NewGRPCServer(ctx context.Context, s *Server, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(LoggingInterceptor(logger.LoggerFromContext(ctx)), AuthInterceptor))
+	srv := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(srv, s)
+	return srv
+}
+
+// SetBaseURL меняет базовый адрес сокращённых URL для последующих запросов
+func (s *Server) SetBaseURL(baseURL string) {
+	s.baseURL.Store(&baseURL)
+}
+
+// currentBaseURL возвращает текущий базовый адрес сокращённых URL
+func (s *Server) currentBaseURL() string {
+	return *s.baseURL.Load()
+}
+
+// Shorten сокращает URL. Если URL уже сокращён, возвращает AlreadyExists
+// с коротким URL в тексте ошибки, как HTTP API отвечает 409 с ним в теле.
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+
+	originalURL, err := s.urls.Normalize(req.GetUrl())
+	if err != nil {
+		log.Errorf("Invalid URL (Shorten) %s", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts, err := shortenOptions(req.GetAlias(), req.GetExpiresAt(), req.GetTtl(), req.GetMaxClicks(), req.GetPassword(), time.Now())
+	if err != nil {
+		log.Errorf("Invalid request (Shorten) %s", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	shortURL, err := s.storeURL.ShortenURL(ctx, userID, originalURL, opts)
+	if err != nil {
+		log.Errorf("ShortenURL error (Shorten) %s", err)
+		return nil, s.shortenError(err, req.GetAlias(), shortURL)
+	}
+	return &pb.ShortenResponse{Result: s.currentBaseURL() + "/" + shortURL}, nil
+}
+
+// BatchShorten сокращает несколько URL. Пакет сохраняется целиком: параметры
+// проверяются до сохранения, а если хранилище не может сохранить одну из ссылок,
+// не сохраняется ни одна и в ошибке указывается её correlation_id.
+func (s *Server) BatchShorten(ctx context.Context, req *pb.BatchShortenRequest) (*pb.BatchShortenResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+
+	now := time.Now()
+	items := req.GetItems()
+	batch := make([]models.BatchItem, len(items))
+	aliases := make(map[string]bool)
+	for i, item := range items {
+		var err error
+		if batch[i].OriginalURL, err = s.urls.Normalize(item.GetOriginalUrl()); err != nil {
+			log.Errorf("Invalid URL (BatchShorten) %s", err)
+			return nil, status.Errorf(codes.InvalidArgument, "correlation_id %q: %s", item.GetCorrelationId(), err)
+		}
+		batch[i].Options, err = shortenOptions(item.GetAlias(), item.GetExpiresAt(), item.GetTtl(), item.GetMaxClicks(), item.GetPassword(), now)
+		if err != nil {
+			log.Errorf("Invalid request (BatchShorten) %s", err)
+			return nil, status.Errorf(codes.InvalidArgument, "correlation_id %q: %s", item.GetCorrelationId(), err)
+		}
+		if item.GetAlias() == "" {
+			continue
+		}
+		if aliases[item.GetAlias()] {
+			log.Errorf("Duplicate alias (BatchShorten) %s", item.GetAlias())
+			return nil, status.Errorf(codes.InvalidArgument, "alias %q is used more than once", item.GetAlias())
+		}
+		aliases[item.GetAlias()] = true
+	}
+
+	shortURLs, err := s.storeURL.BatchShortenURL(ctx, userID, batch)
+	if err != nil {
+		log.Errorf("BatchShortenURL error (BatchShorten) %s", err)
+		i, ok := storageErrors.BatchIndex(err)
+		if !ok {
+			return nil, storeError(err, "could not shorten URLs")
+		}
+		existing, _ := storageErrors.ExistingShortURL(err)
+		st := status.Convert(s.shortenError(err, items[i].GetAlias(), existing))
+		return nil, status.Errorf(st.Code(), "correlation_id %q: %s; no URLs from the batch were saved", items[i].GetCorrelationId(), st.Message())
+	}
+
+	resp := &pb.BatchShortenResponse{Items: make([]*pb.BatchShortenResponse_Item, 0, len(items))}
+	for i, item := range items {
+		resp.Items = append(resp.Items, &pb.BatchShortenResponse_Item{
+			CorrelationId: item.GetCorrelationId(),
+			ShortUrl:      s.currentBaseURL() + "/" + shortURLs[i],
+		})
+	}
+	return resp, nil
+}
+
+// Resolve возвращает оригинальный URL и учитывает переход, как редирект в HTTP API
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+
+	shortURL := req.GetShortUrl()
+	if shortURL == "" {
+		return nil, status.Error(codes.InvalidArgument, "short_url is required")
+	}
+	password := req.GetPassword()
+	var url string
+	err := s.passwordAttempts.Attempt(shortURL, clientIP(ctx, s.proxies), password, time.Now(), func() (err error) {
+		url, err = s.storeURL.RedirectURL(ctx, userID, shortURL, models.RedirectOptions{Password: password})
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTooManyAttempts):
+			log.Errorf("Password attempts exceeded (Resolve) %s", shortURL)
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, storageErrors.ErrPasswordRequired):
+			return nil, status.Error(codes.Unauthenticated, "password required")
+		case errors.Is(err, storageErrors.ErrWrongPassword):
+			return nil, status.Error(codes.PermissionDenied, "wrong password")
+		}
+		log.Errorf("URL is not available (Resolve) %s", err)
+		return nil, storeError(err, "could not resolve URL")
+	}
+	if s.clicks != nil {
+		s.clicks.Record(newClick(ctx, shortURL, s.proxies))
+	}
+	return &pb.ResolveResponse{OriginalUrl: url}, nil
+}
+
+// ListUserURLs возвращает ссылки пользователя
+func (s *Server) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+
+	urls, err := s.storeURL.GetUserURLs(ctx, userID, s.currentBaseURL(), models.ListOptions{IncludeExpired: req.GetIncludeExpired()})
+	if err != nil {
+		log.Errorf("GetUserURLs error (ListUserURLs) %s", err)
+		return nil, storeError(err, "could not list URLs")
+	}
+
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls))}
+	for _, e := range urls {
+		u := &pb.UserURL{
+			ShortUrl:          e.ShortURL,
+			OriginalUrl:       e.OriginalURL,
+			Expired:           e.ExpiredFlag,
+			PasswordProtected: e.PasswordHash != "",
+		}
+		if e.ExpiresAt != nil {
+			u.ExpiresAt = timestamppb.New(*e.ExpiresAt)
+		}
+		if e.ClicksLeft != nil {
+			clicksLeft := int32(*e.ClicksLeft)
+			u.ClicksLeft = &clicksLeft
+		}
+		resp.Urls = append(resp.Urls, u)
+	}
+	return resp, nil
+}
+
+// DeleteUserURLs ставит URL пользователя в очередь на удаление
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	userID, _ := ctx.Value(auth.UserIDKey).(string)
+
+	if s.deletes == nil {
+		if _, err := s.storeURL.DeleteUserURLs(ctx, userID, req.GetShortUrls()); err != nil {
+			log.Errorf("DeleteUserURLs error %s", err)
+			return nil, storeError(err, "could not delete URLs")
+		}
+	} else if err := s.deletes.Enqueue(userID, req.GetShortUrls()); err != nil {
+		log.Errorf("DeleteUserURLs enqueue error %s", err)
+		return nil, status.Error(codes.Unavailable, "delete queue is full, retry later")
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// Ping проверяет доступность хранилища
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	if err := s.storeURL.Ping(ctx); err != nil {
+		log.Errorf("Database connection failed (Ping) %s", err)
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// Stats возвращает статистику сервиса, если адрес из метаданных x-real-ip входит в доверенную подсеть
+func (s *Server) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.StatsResponse, error) {
+	log := logger.LoggerFromContext(ctx)
+	ip := firstMetadata(ctx, RealIPMetadataKey)
+	if s.trusted == nil || !s.trusted.ContainsIP(ip) {
+		log.Infow("Rejected request from untrusted address", "method", "Stats", "ip", ip)
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	}
+
+	stats, err := s.storeURL.GetServiceStats(ctx)
+	if err != nil {
+		log.Errorf("GetServiceStats error (Stats) %s", err)
+		return nil, storeError(err, "could not get stats")
+	}
+	return &pb.StatsResponse{Urls: int32(stats.URLs), Users: int32(stats.Users)}, nil
+}
+
+// shortenOptions проверяет параметры сокращения по тем же правилам, что и HTTP API
+func shortenOptions(alias string, expiresAt *timestamppb.Timestamp, ttl string, maxClicks int32, password string, now time.Time) (models.ShortenOptions, error) {
+	p := utils.ShortenParams{Alias: alias, TTL: ttl, MaxClicks: int(maxClicks), Password: password}
+	if expiresAt != nil {
+		p.ExpiresAt = expiresAt.AsTime()
+	}
+	return utils.ShortenOptions(p, now)
+}
+
+// shortenError переводит ошибку сохранения URL в статус gRPC
+func (s *Server) shortenError(err error, alias, shortURL string) error {
+	switch {
+	case errors.Is(err, storageErrors.ErrUnique):
+		return status.Error(codes.AlreadyExists, fmt.Sprintf("URL is already shortened: %s/%s", s.currentBaseURL(), shortURL))
+	case errors.Is(err, storageErrors.ErrAliasTaken):
+		return status.Errorf(codes.AlreadyExists, "alias %q is already taken", alias)
+	}
+	return storeError(err, "could not shorten URL")
+}
+
+// storeKindCodes сопоставляет видам ошибок хранилища статусы gRPC.
+// У gRPC нет аналога HTTP 410, поэтому недоступная ссылка считается ненайденной.
+var storeKindCodes = []struct {
+	kind error
+	code codes.Code
+}{
+	{storageErrors.ErrNotFound, codes.NotFound},
+	{storageErrors.ErrGone, codes.NotFound},
+	{storageErrors.ErrConflict, codes.AlreadyExists},
+	{storageErrors.ErrInvalid, codes.InvalidArgument},
+	{storageErrors.ErrUnavailable, codes.Unavailable},
+	{storageErrors.ErrPermissionDenied, codes.PermissionDenied},
+}
+
+// storeError переводит ошибку хранилища в статус gRPC по её виду. Текст ошибки
+// клиенту не отдаётся: для известного вида - текст вида, иначе - internal с сообщением msg.
+func storeError(err error, msg string) error {
+	for _, k := range storeKindCodes {
+		if errors.Is(err, k.kind) {
+			return status.Error(k.code, k.kind.Error())
+		}
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+// newClick описывает переход по ссылке shortURL из метаданных запроса.
+// Адрес из x-real-ip учитывается, только если соединение пришло от доверенного прокси из proxies.
+func newClick(ctx context.Context, shortURL string, proxies *subnet.Trusted) models.Click {
+	click := models.Click{ShortURL: shortURL, Time: time.Now().UTC()}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		click.UserAgent = ua[0]
+	}
+	click.IP = analytics.TruncateIP(clientIP(ctx, proxies))
+	return click
+}
+
+// clientIP возвращает адрес клиента так же, как analytics.ClientIP в HTTP API
+func clientIP(ctx context.Context, proxies *subnet.Trusted) string {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	if real := firstMetadata(ctx, RealIPMetadataKey); real != "" && proxies != nil && proxies.ContainsIP(ip) {
+		ip = real
+	}
+	return ip
+}
@@ -0,0 +1,250 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/storage"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/subnet"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	pb "github.com/11Petrov/urlshortener/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testBaseURL = "http://localhost:8080"
+
+// newTestClient запускает сервер поверх bufconn и возвращает клиент к нему;
+// attempts - лимитер попыток пароля, nil - собственный лимитер сервера
+func newTestClient(t *testing.T, attempts *utils.AttemptLimiter) pb.ShortenerClient {
+	t.Helper()
+	trusted, err := subnet.NewTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	srv := NewGRPCServer(ctx, NewServer(storage.NewMemoryStore(utils.HashGenerator{}), testBaseURL, nil, nil, trusted, nil, utils.URLNormalizer{}, attempts))
+
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func TestServerAuth(t *testing.T) {
+	client := newTestClient(t, nil)
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://practicum.yandex.ru/"}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, header.Get(AuthMetadataKey), 1, "new user must get a token")
+	token := header.Get(AuthMetadataKey)[0]
+
+	// С тем же токеном пользователь видит свою ссылку и не получает новый токен
+	authCtx := metadata.AppendToOutgoingContext(ctx, AuthMetadataKey, token)
+	header = nil
+	list, err := client.ListUserURLs(authCtx, &pb.ListUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(AuthMetadataKey))
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, resp.GetResult(), list.GetUrls()[0].GetShortUrl())
+
+	// Без токена это другой пользователь
+	list, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.GetUrls())
+}
+
+func TestServerStatusCodes(t *testing.T) {
+	client := newTestClient(t, nil)
+	ctx := context.Background()
+
+	_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/", Alias: "promo"})
+	require.NoError(t, err)
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/locked", Password: "secret"})
+	require.NoError(t, err)
+	locked := utils.GenerateShortURL("https://example.com/locked")
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{
+			name: "url already shortened in another form",
+			call: func() error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "HTTPS://Example.com:443"})
+				return err
+			},
+			want: codes.AlreadyExists,
+		},
+		{
+			name: "alias taken",
+			call: func() error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.org/", Alias: "promo"})
+				return err
+			},
+			want: codes.AlreadyExists,
+		},
+		{
+			name: "invalid url",
+			call: func() error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "ftp://example.org/"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "invalid ttl",
+			call: func() error {
+				_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.org/", Ttl: "-1h"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "duplicate alias in batch",
+			call: func() error {
+				_, err := client.BatchShorten(ctx, &pb.BatchShortenRequest{Items: []*pb.BatchShortenRequest_Item{
+					{CorrelationId: "1", OriginalUrl: "https://a.example/", Alias: "same"},
+					{CorrelationId: "2", OriginalUrl: "https://b.example/", Alias: "same"},
+				}})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "resolve unknown",
+			call: func() error {
+				_, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: "missing"})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		{
+			name: "password required",
+			call: func() error {
+				_, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: locked})
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "wrong password",
+			call: func() error {
+				_, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: locked, Password: "guess"})
+				return err
+			},
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "stats from untrusted address",
+			call: func() error {
+				_, err := client.Stats(metadata.AppendToOutgoingContext(ctx, RealIPMetadataKey, "192.168.0.1"), &pb.StatsRequest{})
+				return err
+			},
+			want: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, status.Code(tt.call()))
+		})
+	}
+
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: "promo"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/", resolved.GetOriginalUrl())
+
+	stats, err := client.Stats(metadata.AppendToOutgoingContext(ctx, RealIPMetadataKey, "10.1.2.3"), &pb.StatsRequest{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stats.GetUrls())
+}
+
+func TestServerSharedAttemptLimiter(t *testing.T) {
+	attempts := utils.NewAttemptLimiter(utils.MaxPasswordAttempts, utils.PasswordAttemptsWindow)
+	client := newTestClient(t, attempts)
+	ctx := context.Background()
+
+	_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/locked", Password: "secret"})
+	require.NoError(t, err)
+	locked := utils.GenerateShortURL("https://example.com/locked")
+
+	// попытки, израсходованные через HTTP API, учитываются и в gRPC
+	// bufconn не сообщает адрес соединения, поэтому клиент gRPC здесь без адреса
+	for i := 0; i < utils.MaxPasswordAttempts; i++ {
+		require.True(t, attempts.Reserve(locked, "", time.Now()))
+	}
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{ShortUrl: locked, Password: "secret"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"not found", storageErrors.ErrNotFound, codes.NotFound},
+		{"deleted", storageErrors.ErrDeleted, codes.NotFound},
+		{"conflict", storageErrors.NewConflict("abc"), codes.AlreadyExists},
+		{"unavailable", fmt.Errorf("%w: connection refused", storageErrors.ErrUnavailable), codes.Unavailable},
+		{"unknown", errors.New("boom"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(storeError(tt.err, "failed"))
+			assert.Equal(t, tt.want, st.Code())
+			assert.NotContains(t, st.Message(), "connection refused")
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := subnet.NewTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		proxies *subnet.Trusted
+		peer    string
+		realIP  string
+		want    string
+	}{
+		{name: "peer", proxies: proxies, peer: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted proxy", proxies: proxies, peer: "10.1.2.3", realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "untrusted peer", proxies: proxies, peer: "203.0.113.7", realIP: "198.51.100.1", want: "203.0.113.7"},
+		{name: "no trusted proxies", peer: "10.1.2.3", realIP: "198.51.100.1", want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 5000}})
+			if tt.realIP != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RealIPMetadataKey, tt.realIP))
+			}
+			assert.Equal(t, tt.want, clientIP(ctx, tt.proxies))
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/problem"
 )
 
 // compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
@@ -14,6 +15,8 @@ import (
 type compressWriter struct {
 	w  http.ResponseWriter
 	zw *gzip.Writer
+	// plain выставляется для ответов с кодом от 300: их тело передаётся без сжатия
+	plain bool
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
@@ -28,18 +31,26 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.plain {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
 	if statusCode < 300 {
 		c.w.Header().Set("Content-Encoding", "gzip")
+	} else {
+		c.plain = true
 	}
 	c.w.WriteHeader(statusCode)
 }
 
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *compressWriter) Close() error {
+	if c.plain {
+		return nil
+	}
 	return c.zw.Close()
 }
 
@@ -101,7 +112,7 @@ func GzipMiddleware(h http.HandlerFunc) http.HandlerFunc {
 			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
 			cr, err := newCompressReader(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Write(ow, r, http.StatusBadRequest, problem.CodeInvalidEncoding, "request body is not valid gzip")
 				return
 			}
 			// меняем тело запроса на новое
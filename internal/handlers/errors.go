@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/problem"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/utils"
+)
+
+// storeError - статус ответа и код ошибки для ошибки хранилища err
+type storeError struct {
+	err    error
+	status int
+	code   string
+}
+
+// storeErrors сопоставляет отдельным ошибкам хранилища статус ответа и код ошибки
+var storeErrors = []storeError{
+	{storageErrors.ErrUnique, http.StatusConflict, problem.CodeURLExists},
+	{storageErrors.ErrAliasTaken, http.StatusConflict, problem.CodeAliasTaken},
+	{storageErrors.ErrBatchDuplicate, http.StatusConflict, problem.CodeConflict},
+	{storageErrors.ErrDeleted, http.StatusGone, problem.CodeDeleted},
+	{storageErrors.ErrExpired, http.StatusGone, problem.CodeExpired},
+	{storageErrors.ErrClicksExhausted, http.StatusGone, problem.CodeClicksExhausted},
+	{storageErrors.ErrPasswordRequired, http.StatusUnauthorized, problem.CodePasswordRequired},
+	{storageErrors.ErrWrongPassword, http.StatusUnauthorized, problem.CodeWrongPassword},
+	{storageErrors.ErrForbidden, http.StatusForbidden, problem.CodeForbidden},
+}
+
+// storeErrorKinds сопоставляет видам ошибок хранилища статус ответа и код ошибки.
+// По ним отвечают на ошибки, которых нет в storeErrors, например на ошибки базы данных.
+var storeErrorKinds = []storeError{
+	{storageErrors.ErrNotFound, http.StatusNotFound, problem.CodeNotFound},
+	{storageErrors.ErrGone, http.StatusGone, problem.CodeGone},
+	{storageErrors.ErrConflict, http.StatusConflict, problem.CodeConflict},
+	{storageErrors.ErrInvalid, http.StatusBadRequest, problem.CodeInvalidRequest},
+	{storageErrors.ErrUnavailable, http.StatusServiceUnavailable, problem.CodeUnavailable},
+	{storageErrors.ErrPermissionDenied, http.StatusForbidden, problem.CodeForbidden},
+}
+
+// optionErrorCodes сопоставляет видам ошибок проверки параметров сокращения коды ошибок
+var optionErrorCodes = []struct {
+	kind error
+	code string
+}{
+	{utils.ErrInvalidAlias, problem.CodeInvalidAlias},
+	{utils.ErrInvalidExpiration, problem.CodeInvalidExpiration},
+	{utils.ErrInvalidMaxClicks, problem.CodeInvalidMaxClicks},
+	{utils.ErrInvalidPassword, problem.CodeInvalidPassword},
+}
+
+// optionErrorCode возвращает код ошибки для ошибки utils.ShortenOptions
+func optionErrorCode(err error) string {
+	for _, e := range optionErrorCodes {
+		if errors.Is(err, e.kind) {
+			return e.code
+		}
+	}
+	return problem.CodeInvalidRequest
+}
+
+// findStoreError ищет ошибку err сначала среди отдельных ошибок, затем среди видов;
+// kind равен true, если ошибка опознана только по виду
+func findStoreError(err error) (e storeError, kind, ok bool) {
+	for _, e := range storeErrors {
+		if errors.Is(err, e.err) {
+			return e, false, true
+		}
+	}
+	for _, e := range storeErrorKinds {
+		if errors.Is(err, e.err) {
+			return e, true, true
+		}
+	}
+	return storeError{}, false, false
+}
+
+// storeErrorStatus возвращает статус ответа и код для ошибки хранилища;
+// ok равен false, если ошибка не относится ни к одному виду ошибок хранилища
+func storeErrorStatus(err error) (status int, code string, ok bool) {
+	e, _, ok := findStoreError(err)
+	if !ok {
+		return http.StatusInternalServerError, problem.CodeInternal, false
+	}
+	return e.status, e.code, true
+}
+
+// writeStoreError отправляет ответ с ошибкой хранилища. Если detail пуст, клиенту
+// отдаётся текст ошибки; для ошибок, опознанных только по виду, и для неизвестных
+// ошибок полный текст может раскрыть детали хранилища и попадает только в лог.
+func writeStoreError(rw http.ResponseWriter, r *http.Request, err error, detail string) {
+	e, kind, ok := findStoreError(err)
+	if !ok {
+		log := logger.LoggerFromContext(r.Context())
+		log.Errorf("Storage error %s %s", r.URL.Path, err)
+		problem.Write(rw, r, http.StatusInternalServerError, problem.CodeInternal, "internal server error")
+		return
+	}
+	if detail == "" {
+		detail = err.Error()
+		if kind {
+			logger.LoggerFromContext(r.Context()).Errorf("Storage error %s %s", r.URL.Path, err)
+			detail = e.err.Error()
+		}
+	}
+	if errors.Is(err, storageErrors.ErrPasswordRequired) || errors.Is(err, storageErrors.ErrWrongPassword) {
+		rw.Header().Set("WWW-Authenticate", passwordAuthChallenge)
+	}
+	problem.Write(rw, r, e.status, e.code, detail)
+}
+
+// batchErrorDetail возвращает текст ошибки пакета err, вызванной элементом val:
+// какой элемент не удалось сохранить и почему. Причина берётся из найденной ошибки
+// хранилища или её вида, поэтому детали хранилища клиенту не попадают.
+func batchErrorDetail(err error, val models.BatchRequest) string {
+	reason := "internal server error"
+	if e, _, ok := findStoreError(err); ok {
+		reason = e.err.Error()
+	}
+	if errors.Is(err, storageErrors.ErrAliasTaken) {
+		reason = fmt.Sprintf("alias %q is already taken", val.Alias)
+	}
+	return fmt.Sprintf("correlation_id %q: %s; no URLs from the batch were saved", val.CorrelationID, reason)
+}
+
+// writeUnauthorized отвечает 401, если в контексте запроса нет пользователя
+func writeUnauthorized(rw http.ResponseWriter, r *http.Request) {
+	problem.Write(rw, r, http.StatusUnauthorized, problem.CodeUnauthorized, "user is not authenticated")
+}
@@ -3,46 +3,103 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/11Petrov/urlshortener/internal/analytics"
 	"github.com/11Petrov/urlshortener/internal/auth"
 	"github.com/11Petrov/urlshortener/internal/logger"
 	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/problem"
+	"github.com/11Petrov/urlshortener/internal/storage"
 	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/subnet"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	"github.com/go-chi/chi"
 )
 
 // handlerURLStore определяет приватный интерфейс для хранилища URL
 type handlerURLStore interface {
-	ShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	RedirectURL(ctx context.Context, userID, shortURL string) (string, error)
+	ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error)
+	RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error)
 	Ping(ctx context.Context) error
-	BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	GetUserURLs(ctx context.Context, userID, baseURL string) ([]models.Event, error)
-	DeleteUserURLs(ctx context.Context, userID string, shortURL []string) error
+	BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error)
+	GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error)
+	DeleteUserURLs(ctx context.Context, userID string, shortURL []string) (int, error)
+	RestoreUserURLs(ctx context.Context, userID string, shortURL []string, deletedAfter time.Time) ([]string, error)
+	UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error)
+	GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error)
+	GetServiceStats(ctx context.Context) (models.ServiceStats, error)
+}
+
+// clickRecorder принимает переходы по ссылкам для статистики
+type clickRecorder interface {
+	Record(click models.Click)
+}
+
+// deleteQueue принимает запросы на асинхронное удаление URL пользователя
+type deleteQueue interface {
+	Enqueue(userID string, shortURLs []string) error
 }
 
 // URLHandler обрабатывает HTTP-запросы
 type HandlerURL struct {
-	storeURL handlerURLStore
-	baseURL  string
+	storeURL         handlerURLStore
+	baseURL          atomic.Pointer[string]
+	passwordAttempts *utils.AttemptLimiter
+	clicks           clickRecorder
+	deletes          deleteQueue
+	// deleteGrace - сколько удалённую ссылку можно восстановить
+	deleteGrace time.Duration
+	// urls проверяет сокращаемые URL и приводит их к каноническому виду
+	urls utils.URLNormalizer
+	// proxies - подсеть прокси, которым доверяется адрес клиента из заголовков
+	proxies *subnet.Trusted
 }
 
-// NewURLHandler создает новый экземпляр URLHandler
-func NewHandlerURL(storeURL handlerURLStore, baseURL string) *HandlerURL {
-	return &HandlerURL{
-		storeURL: storeURL,
-		baseURL:  baseURL,
+// NewURLHandler создает новый экземпляр URLHandler.
+// Если clicks равен nil, переходы не записываются.
+// Если deletes равен nil, URL удаляются синхронно в рамках запроса.
+// Лимитер попыток пароля attempts общий с другими API сервиса; если он равен nil, создаётся собственный.
+// Адрес клиента для статистики берётся из заголовков только у запросов из подсети proxies.
+func NewHandlerURL(storeURL handlerURLStore, baseURL string, clicks clickRecorder, deletes deleteQueue, deleteGrace time.Duration, urls utils.URLNormalizer, attempts *utils.AttemptLimiter, proxies *subnet.Trusted) *HandlerURL {
+	if attempts == nil {
+		attempts = utils.NewAttemptLimiter(utils.MaxPasswordAttempts, utils.PasswordAttemptsWindow)
+	}
+	h := &HandlerURL{
+		storeURL:         storeURL,
+		passwordAttempts: attempts,
+		clicks:           clicks,
+		deletes:          deletes,
+		deleteGrace:      deleteGrace,
+		urls:             urls,
+		proxies:          proxies,
 	}
+	h.SetBaseURL(baseURL)
+	return h
+}
+
+// SetBaseURL меняет базовый адрес сокращённых URL для последующих запросов
+func (h *HandlerURL) SetBaseURL(baseURL string) {
+	h.baseURL.Store(&baseURL)
+}
+
+// currentBaseURL возвращает текущий базовый адрес сокращённых URL
+func (h *HandlerURL) currentBaseURL() string {
+	return *h.baseURL.Load()
 }
 
 // ShortenURL обрабатывает запросы на сокращение URL
 func (h *HandlerURL) ShortenURL(rw http.ResponseWriter, r *http.Request) {
 	log := logger.LoggerFromContext(r.Context())
 	if r.ContentLength == 0 {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidRequest, "request body is missing")
 		log.Error("Request body is missing (ShortenURL)")
 		return
 	}
@@ -51,7 +108,7 @@ func (h *HandlerURL) ShortenURL(rw http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidRequest, "could not read request body")
 		log.Errorf("Error reading request (ShortenURL) %s", err)
 		return
 	}
@@ -60,29 +117,36 @@ func (h *HandlerURL) ShortenURL(rw http.ResponseWriter, r *http.Request) {
 	if !ok {
 		log.Error("error userID ShortenURL")
 	}
-	originalURL := string(body)
-	shortURL, err := h.storeURL.ShortenURL(r.Context(), userID, originalURL)
+	originalURL, err := h.urls.Normalize(string(body))
 	if err != nil {
-		if err == storageErrors.ErrUnique {
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidURL, err.Error())
+		log.Errorf("Invalid URL (ShortenURL) %s", err)
+		return
+	}
+	shortURL, err := h.storeURL.ShortenURL(r.Context(), userID, originalURL, models.ShortenOptions{})
+	if err != nil {
+		if errors.Is(err, storageErrors.ErrUnique) {
+			rw.Header().Set("Content-Type", "text/plain")
 			rw.WriteHeader(http.StatusConflict)
-			responseURL := h.baseURL + "/" + shortURL
+			responseURL := h.currentBaseURL() + "/" + shortURL
 			rw.Write([]byte(responseURL))
 			log.Errorf("URL already in database (ShortenURL) %s", err)
 			return
-		} else {
-			rw.WriteHeader(http.StatusBadRequest)
-			log.Errorf("ShortenURL error %s", err)
-			return
 		}
+		writeStoreError(rw, r, err, "")
+		log.Errorf("ShortenURL error %s", err)
+		return
 	}
-	responseURL := h.baseURL + "/" + shortURL
+	responseURL := h.currentBaseURL() + "/" + shortURL
 
-	rw.WriteHeader(http.StatusCreated)
 	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusCreated)
 	rw.Write([]byte(responseURL))
 }
 
-// RedirectURL обрабатывает запросы на перенаправление по сокращенному URL
+// RedirectURL обрабатывает запросы на перенаправление по сокращенному URL.
+// Пароль защищённой ссылки принимается из заголовка PasswordHeader или,
+// для POST-запроса, из поля password HTML-формы.
 func (h *HandlerURL) RedirectURL(rw http.ResponseWriter, r *http.Request) {
 	log := logger.LoggerFromContext(r.Context())
 	log.Info("Processing RediretURL handler")
@@ -91,7 +155,7 @@ func (h *HandlerURL) RedirectURL(rw http.ResponseWriter, r *http.Request) {
 	path := strings.Split(r.URL.Path, "/")
 	shortURL := path[1]
 	if len(shortURL) == 0 {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidRequest, "short URL is missing")
 		log.Error("Empty URL parameter (RedirectURL)")
 		return
 	}
@@ -100,13 +164,42 @@ func (h *HandlerURL) RedirectURL(rw http.ResponseWriter, r *http.Request) {
 	if !ok {
 		log.Error("error userID RedirectURL")
 	}
-	url, err := h.storeURL.RedirectURL(ctx, userID, shortURL)
+
+	password := r.Header.Get(PasswordHeader)
+	if r.Method == http.MethodPost {
+		password = r.FormValue("password")
+	}
+	var url string
+	err := h.passwordAttempts.Attempt(shortURL, analytics.ClientIP(r, h.proxies), password, time.Now(), func() (err error) {
+		url, err = h.storeURL.RedirectURL(ctx, userID, shortURL, models.RedirectOptions{Password: password})
+		return err
+	})
 	if err != nil {
-		log.Errorf("URL not found (RedirectURL) %s", err)
-		rw.WriteHeader(http.StatusGone)
+		switch {
+		case errors.Is(err, utils.ErrTooManyAttempts):
+			problem.Write(rw, r, http.StatusTooManyRequests, problem.CodeTooManyAttempts, err.Error())
+			log.Errorf("Password attempts exceeded (RedirectURL) %s", shortURL)
+			return
+		case errors.Is(err, storageErrors.ErrPasswordRequired):
+			passwordChallenge(rw, r, shortURL, problem.CodePasswordRequired, "password required")
+			return
+		case errors.Is(err, storageErrors.ErrWrongPassword):
+			passwordChallenge(rw, r, shortURL, problem.CodeWrongPassword, "wrong password")
+			return
+		}
+		log.Errorf("URL is not available (RedirectURL) %s", err)
+		writeStoreError(rw, r, err, "")
 		return
 	}
+	if h.clicks != nil {
+		h.clicks.Record(analytics.NewClick(r, shortURL, h.proxies))
+	}
 	rw.Header().Set("Location", url)
+	if r.Method == http.MethodPost {
+		// после отправки формы браузер должен перейти по ссылке методом GET
+		rw.WriteHeader(http.StatusSeeOther)
+		return
+	}
 	rw.WriteHeader(http.StatusTemporaryRedirect)
 }
 
@@ -116,50 +209,61 @@ func (h *HandlerURL) JSONShortenURL(rw http.ResponseWriter, r *http.Request) {
 	var req models.JSONShortenURLRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidJSON, "invalid JSON body")
 		log.Errorf("Invalid decode json (JSONShortenURL) %s", err)
 		return
 	}
 	userID, ok := r.Context().Value(auth.UserIDKey).(string)
 	if !ok {
 		log.Error("error userID JsonShortenURL")
+		writeUnauthorized(rw, r)
 		return
 	}
-	shortURL, err := h.storeURL.ShortenURL(r.Context(), userID, req.URL)
+	originalURL, err := h.urls.Normalize(req.URL)
 	if err != nil {
-		if err == storageErrors.ErrUnique {
-			rw.Header().Set("Content-Type", "application/json")
-			rw.WriteHeader(http.StatusConflict)
-			resp := models.JSONShortenURLResponse{Result: h.baseURL + "/" + shortURL}
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidURL, err.Error())
+		log.Errorf("Invalid URL (JSONShortenURL) %s", err)
+		return
+	}
+	opts, err := utils.ShortenOptions(utils.ShortenParams{Alias: req.Alias, ExpiresAt: req.ExpiresAt, TTL: req.TTL, MaxClicks: req.MaxClicks, Password: req.Password}, time.Now())
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, optionErrorCode(err), err.Error())
+		log.Errorf("Invalid request (JSONShortenURL) %s", err)
+		return
+	}
+	shortURL, err := h.storeURL.ShortenURL(r.Context(), userID, originalURL, opts)
+	status := http.StatusCreated
+	if err != nil {
+		switch {
+		case errors.Is(err, storageErrors.ErrAliasTaken):
+			writeStoreError(rw, r, err, fmt.Sprintf("alias %q is already taken", req.Alias))
+			log.Errorf("Alias already taken (JSONShortenURL) %s", err)
+			return
+		case errors.Is(err, storageErrors.ErrUnique):
+			// Клиенты ждут в ответе 409 уже существующую короткую ссылку в обычном формате
+			status = http.StatusConflict
 			log.Errorf("URL already in database (JSONShortenURL) %s", err)
-			if err := json.NewEncoder(rw).Encode(resp); err != nil {
-				rw.WriteHeader(http.StatusBadRequest)
-				log.Errorf("Invalid encode json (JSONShortenURL) %s", err)
-				return
-			}
-		} else {
-			rw.WriteHeader(http.StatusBadRequest)
+		default:
+			writeStoreError(rw, r, err, "")
+			log.Errorf("ShortenURL error (JSONShortenURL) %s", err)
 			return
 		}
-	} else {
-		resp := models.JSONShortenURLResponse{Result: h.baseURL + "/" + shortURL}
-
-		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusCreated)
+	}
 
-		if err := json.NewEncoder(rw).Encode(resp); err != nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			log.Errorf("Invalid encode json (JSONShortenURL) %s", err)
-			return
-		}
+	resp := models.JSONShortenURLResponse{Result: h.currentBaseURL() + "/" + shortURL}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		log.Errorf("Invalid encode json (JSONShortenURL) %s", err)
 	}
 }
 
+// Ping проверяет соединение с хранилищем; недоступное хранилище - это 503, как Unavailable в gRPC
 func (h *HandlerURL) Ping(rw http.ResponseWriter, r *http.Request) {
 	log := logger.LoggerFromContext(r.Context())
 	err := h.storeURL.Ping(r.Context())
 	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusServiceUnavailable, problem.CodeUnavailable, storageErrors.ErrUnavailable.Error())
 		log.Errorf("Database connection failed (Ping) %s", err)
 		return
 	}
@@ -167,43 +271,74 @@ func (h *HandlerURL) Ping(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 }
 
+// BatchShortenURL сокращает пакет URL. Пакет сохраняется целиком: если хотя бы одну
+// ссылку сохранить нельзя, в ответе с ошибкой указывается её correlation_id,
+// а остальные ссылки пакета тоже не сохраняются.
 func (h *HandlerURL) BatchShortenURL(rw http.ResponseWriter, r *http.Request) {
 	log := logger.LoggerFromContext(r.Context())
 	var arrRequest []models.BatchRequest
 	var arrResponse []models.BatchResponse
 
 	if err := json.NewDecoder(r.Body).Decode(&arrRequest); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidJSON, "invalid JSON body")
 		log.Errorf("Invalid decode json (BatchShortenURL) %s", err)
 		return
 	}
 
-	for _, val := range arrRequest {
-		userID, ok := r.Context().Value(auth.UserIDKey).(string)
-		if !ok {
-			log.Error("error userID BatchShortenURL")
+	// Параметры проверяются до сохранения, чтобы ошибка в одном из них не оставила пакет сохранённым частично
+	now := time.Now()
+	items := make([]models.BatchItem, len(arrRequest))
+	aliases := make(map[string]bool)
+	for i, val := range arrRequest {
+		var err error
+		if items[i].OriginalURL, err = h.urls.Normalize(val.OriginalURL); err != nil {
+			problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidURL, fmt.Sprintf("correlation_id %q: %s", val.CorrelationID, err))
+			log.Errorf("Invalid URL (BatchShortenURL) %s", err)
+			return
 		}
-		shortURL, err := h.storeURL.BatchShortenURL(r.Context(), userID, val.OriginalURL)
-		if err != nil {
-			log.Errorf("BatchShortenURL error %s", err)
+		if items[i].Options, err = utils.ShortenOptions(utils.ShortenParams{Alias: val.Alias, ExpiresAt: val.ExpiresAt, TTL: val.TTL, MaxClicks: val.MaxClicks, Password: val.Password}, now); err != nil {
+			problem.Write(rw, r, http.StatusBadRequest, optionErrorCode(err), fmt.Sprintf("correlation_id %q: %s", val.CorrelationID, err))
+			log.Errorf("Invalid request (BatchShortenURL) %s", err)
 			return
 		}
-		url := h.baseURL + "/" + shortURL
-		resp := models.BatchResponse{
-			CorrelationID: val.CorrelationID,
-			ShortURL:      url,
+
+		if val.Alias == "" {
+			continue
 		}
+		if aliases[val.Alias] {
+			problem.Write(rw, r, http.StatusBadRequest, problem.CodeDuplicateAlias, fmt.Sprintf("alias %q is used more than once", val.Alias))
+			log.Errorf("Duplicate alias (BatchShortenURL) %s", val.Alias)
+			return
+		}
+		aliases[val.Alias] = true
+	}
 
-		arrResponse = append(arrResponse, resp)
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		log.Error("error userID BatchShortenURL")
+	}
+	shortURLs, err := h.storeURL.BatchShortenURL(r.Context(), userID, items)
+	if err != nil {
+		detail := ""
+		if i, ok := storageErrors.BatchIndex(err); ok {
+			detail = batchErrorDetail(err, arrRequest[i])
+		}
+		writeStoreError(rw, r, err, detail)
+		log.Errorf("BatchShortenURL error %s", err)
+		return
+	}
+	for i, val := range arrRequest {
+		arrResponse = append(arrResponse, models.BatchResponse{
+			CorrelationID: val.CorrelationID,
+			ShortURL:      h.currentBaseURL() + "/" + shortURLs[i],
+		})
 	}
 
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusCreated)
 
 	if err := json.NewEncoder(rw).Encode(&arrResponse); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
 		log.Errorf("Invalid encode json (BatchShortenURL) %s", err)
-		return
 	}
 }
 
@@ -212,13 +347,14 @@ func (h *HandlerURL) GetUserURLs(rw http.ResponseWriter, r *http.Request) {
 
 	userID, ok := r.Context().Value(auth.UserIDKey).(string)
 	if !ok {
-		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(rw, r)
 		return
 	}
 
-	urls, err := h.storeURL.GetUserURLs(r.Context(), userID, h.baseURL)
+	includeExpired, _ := strconv.ParseBool(r.URL.Query().Get("include_expired"))
+	urls, err := h.storeURL.GetUserURLs(r.Context(), userID, h.currentBaseURL(), models.ListOptions{IncludeExpired: includeExpired})
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(rw, r, err, "")
 		log.Errorf("GetUserURLs error %s", err)
 		return
 	}
@@ -228,40 +364,192 @@ func (h *HandlerURL) GetUserURLs(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resp := make([]models.UserURL, 0, len(urls))
+	for _, e := range urls {
+		resp = append(resp, models.UserURL{
+			ShortURL:    e.ShortURL,
+			OriginalURL: e.OriginalURL,
+			ExpiresAt:   e.ExpiresAt,
+			Expired:     e.ExpiredFlag,
+			ClicksLeft:  e.ClicksLeft,
+			Protected:   e.PasswordHash != "",
+		})
+	}
+
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(rw).Encode(urls); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
 		log.Errorf("Invalid encode json (GetUserUrls) %s", err)
-		return
 	}
 }
 
 func (h *HandlerURL) DeleteUserURLs(rw http.ResponseWriter, r *http.Request) {
 	log := logger.LoggerFromContext(r.Context())
-	log.Info("Processing DeleteUserURLs handelr")
-	ctx, cancel := context.WithTimeout(r.Context(), time.Second*60)
-	defer cancel()
 
 	userID, ok := r.Context().Value(auth.UserIDKey).(string)
 	if !ok {
 		log.Error("Error getting user ID in DeleteUserURLs")
+		writeUnauthorized(rw, r)
 		return
 	}
 
 	var urls []string
 	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
-		log.Errorf("Invalid decode json (BatchShortenURL) %s", err)
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidJSON, "expected a JSON array of short URLs")
+		log.Errorf("Invalid decode json (DeleteUserURLs) %s", err)
 		return
 	}
 
-	err := h.storeURL.DeleteUserURLs(ctx, userID, urls)
-	if err != nil {
-		log.Errorf("DeleteUserURLs error", err)
+	if h.deletes == nil {
+		if _, err := h.storeURL.DeleteUserURLs(r.Context(), userID, urls); err != nil {
+			writeStoreError(rw, r, err, "")
+			log.Errorf("DeleteUserURLs error %s", err)
+			return
+		}
+	} else if err := h.deletes.Enqueue(userID, urls); err != nil {
+		problem.Write(rw, r, http.StatusServiceUnavailable, problem.CodeQueueFull, "delete queue is full, retry later")
+		log.Errorf("DeleteUserURLs enqueue error %s", err)
+		return
 	}
 
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusAccepted)
 }
+
+// RestoreUserURLs восстанавливает удалённые URL пользователя, если срок восстановления не истёк,
+// и возвращает список восстановленных коротких URL
+func (h *HandlerURL) RestoreUserURLs(rw http.ResponseWriter, r *http.Request) {
+	log := logger.LoggerFromContext(r.Context())
+
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		writeUnauthorized(rw, r)
+		return
+	}
+
+	var urls []string
+	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidJSON, "expected a JSON array of short URLs")
+		log.Errorf("Invalid decode json (RestoreUserURLs) %s", err)
+		return
+	}
+
+	restored, err := h.storeURL.RestoreUserURLs(r.Context(), userID, urls, time.Now().Add(-h.deleteGrace))
+	if err != nil {
+		writeStoreError(rw, r, err, "")
+		log.Errorf("RestoreUserURLs error %s", err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(restored); err != nil {
+		log.Errorf("Invalid encode json (RestoreUserURLs) %s", err)
+	}
+}
+
+// GetURLStats возвращает владельцу статистику переходов по ссылке.
+// Параметр bucket задаёт группировку переходов: hour или day (по умолчанию).
+func (h *HandlerURL) GetURLStats(rw http.ResponseWriter, r *http.Request) {
+	log := logger.LoggerFromContext(r.Context())
+
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		writeUnauthorized(rw, r)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "id")
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = storage.BucketDay
+	}
+	if bucket != storage.BucketDay && bucket != storage.BucketHour {
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidBucket, fmt.Sprintf("bucket must be %q or %q", storage.BucketHour, storage.BucketDay))
+		return
+	}
+
+	stats, err := h.storeURL.GetURLStats(r.Context(), userID, shortURL, bucket)
+	if err != nil {
+		writeStoreError(rw, r, err, "")
+		log.Errorf("GetURLStats error %s", err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(stats); err != nil {
+		log.Errorf("Invalid encode json (GetURLStats) %s", err)
+	}
+}
+
+// UpdateURL меняет оригинальный URL ссылки пользователя.
+// Прежнее значение сохраняется в истории правок, которая возвращается в ответе.
+func (h *HandlerURL) UpdateURL(rw http.ResponseWriter, r *http.Request) {
+	log := logger.LoggerFromContext(r.Context())
+
+	userID, ok := r.Context().Value(auth.UserIDKey).(string)
+	if !ok {
+		writeUnauthorized(rw, r)
+		return
+	}
+
+	var req models.EditURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidJSON, "invalid JSON body")
+		log.Errorf("Invalid decode json (UpdateURL) %s", err)
+		return
+	}
+	originalURL, err := h.urls.Normalize(req.URL)
+	if err != nil {
+		problem.Write(rw, r, http.StatusBadRequest, problem.CodeInvalidURL, err.Error())
+		log.Errorf("Invalid URL (UpdateURL) %s", err)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "id")
+	event, err := h.storeURL.UpdateURL(r.Context(), userID, shortURL, originalURL)
+	if err != nil {
+		detail := ""
+		if errors.Is(err, storageErrors.ErrUnique) {
+			detail = "url is already shortened by another link"
+		}
+		writeStoreError(rw, r, err, detail)
+		log.Errorf("UpdateURL error %s", err)
+		return
+	}
+
+	resp := models.EditURLResponse{
+		ShortURL:    h.currentBaseURL() + "/" + event.ShortURL,
+		OriginalURL: event.OriginalURL,
+		Revisions:   event.Revisions,
+	}
+	if resp.Revisions == nil {
+		resp.Revisions = []models.Revision{}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		log.Errorf("Invalid encode json (UpdateURL) %s", err)
+	}
+}
+
+// GetServiceStats возвращает число сокращённых URL и пользователей сервиса.
+// Доступ ограничивается доверенной подсетью на уровне маршрута.
+func (h *HandlerURL) GetServiceStats(rw http.ResponseWriter, r *http.Request) {
+	log := logger.LoggerFromContext(r.Context())
+
+	stats, err := h.storeURL.GetServiceStats(r.Context())
+	if err != nil {
+		writeStoreError(rw, r, err, "")
+		log.Errorf("GetServiceStats error %s", err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(stats); err != nil {
+		log.Errorf("Invalid encode json (GetServiceStats) %s", err)
+	}
+}
@@ -2,16 +2,23 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/11Petrov/urlshortener/cmd/config"
 	"github.com/11Petrov/urlshortener/internal/auth"
 	"github.com/11Petrov/urlshortener/internal/logger"
 	"github.com/11Petrov/urlshortener/internal/models"
+	"github.com/11Petrov/urlshortener/internal/problem"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
 	"github.com/11Petrov/urlshortener/internal/utils"
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
@@ -19,12 +26,16 @@ import (
 )
 
 type TestURLStore interface {
-	ShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	RedirectURL(ctx context.Context, userID, shortURL string) (string, error)
+	ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error)
+	RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error)
 	Ping(ctx context.Context) error
-	BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	GetUserURLs(ctx context.Context, userID, baseURL string) ([]models.Event, error)
-	DeleteUserURLs(ctx context.Context, userID string, shortURL []string) error
+	BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error)
+	GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error)
+	DeleteUserURLs(ctx context.Context, userID string, shortURL []string) (int, error)
+	RestoreUserURLs(ctx context.Context, userID string, shortURL []string, deletedAfter time.Time) ([]string, error)
+	UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error)
+	GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error)
+	GetServiceStats(ctx context.Context) (models.ServiceStats, error)
 }
 
 type testStorage struct {
@@ -37,8 +48,16 @@ func newTestStorage() TestURLStore {
 	}
 }
 
-func (t *testStorage) ShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+func (t *testStorage) ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error) {
 	shortURL := utils.GenerateShortURL(originalURL)
+	if opts.Alias != "" {
+		for _, urls := range t.URLMap {
+			if _, ok := urls[opts.Alias]; ok {
+				return "", storageErrors.ErrAliasTaken
+			}
+		}
+		shortURL = opts.Alias
+	}
 	if _, ok := t.URLMap[userID]; !ok {
 		t.URLMap[userID] = make(map[string]string)
 	}
@@ -46,18 +65,18 @@ func (t *testStorage) ShortenURL(ctx context.Context, userID, originalURL string
 	return shortURL, nil
 }
 
-func (t *testStorage) RedirectURL(ctx context.Context, userID, shortURL string) (string, error) {
+func (t *testStorage) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
 	if userUrls, ok := t.URLMap[userID]; ok {
 		url, ok := userUrls[shortURL]
 		if !ok {
-			return "", errors.New("url not found for this user")
+			return "", storageErrors.ErrNotFound
 		}
 		return url, nil
 	}
-	return "", errors.New("user not found")
+	return "", storageErrors.ErrNotFound
 }
 
-func (t *testStorage) GetUserURLs(ctx context.Context, userID, baseURL string) ([]models.Event, error) {
+func (t *testStorage) GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error) {
 	log := logger.LoggerFromContext(ctx)
 
 	urls, ok := t.URLMap[userID]
@@ -80,10 +99,10 @@ func (t *testStorage) GetUserURLs(ctx context.Context, userID, baseURL string) (
 }
 
 // BatchShortenURL implements URLStore.
-func (t *testStorage) BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+func (t *testStorage) BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error) {
 	log := logger.LoggerFromContext(ctx)
 	log.Info("BatchShortenURL function was called")
-	return "", nil
+	return make([]string, len(items)), nil
 }
 
 // Ping implements URLStore.
@@ -93,10 +112,61 @@ func (t *testStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (t *testStorage) DeleteUserURLs(ctx context.Context, userID string, shortURL []string) error {
+func (t *testStorage) GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error) {
+	return models.URLStats{}, storageErrors.ErrNotFound
+}
+
+func (t *testStorage) RestoreUserURLs(ctx context.Context, userID string, shortURL []string, deletedAfter time.Time) ([]string, error) {
+	return []string{}, nil
+}
+
+func (t *testStorage) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error) {
+	for owner, urls := range t.URLMap {
+		current, ok := urls[shortURL]
+		if !ok {
+			continue
+		}
+		if owner != userID {
+			return models.Event{}, storageErrors.ErrForbidden
+		}
+		urls[shortURL] = originalURL
+		return models.Event{
+			UserID:      userID,
+			ShortURL:    shortURL,
+			OriginalURL: originalURL,
+			Revisions:   []models.Revision{{OriginalURL: current}},
+		}, nil
+	}
+	return models.Event{}, storageErrors.ErrNotFound
+}
+
+func (t *testStorage) GetServiceStats(ctx context.Context) (models.ServiceStats, error) {
+	stats := models.ServiceStats{Users: len(t.URLMap)}
+	for _, urls := range t.URLMap {
+		stats.URLs += len(urls)
+	}
+	return stats, nil
+}
+
+func (t *testStorage) DeleteUserURLs(ctx context.Context, userID string, shortURL []string) (int, error) {
 	log := logger.LoggerFromContext(ctx)
 	log.Info("DeleteUserURLs was called")
-	return nil
+	return 0, nil
+}
+
+// problemBody возвращает ожидаемое тело ответа с ошибкой
+func problemBody(t *testing.T, status int, code, detail, instance string) string {
+	t.Helper()
+	body, err := json.Marshal(problem.Details{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+	})
+	require.NoError(t, err)
+	return string(body)
 }
 
 func TestShortenURL(t *testing.T) {
@@ -119,12 +189,24 @@ func TestShortenURL(t *testing.T) {
 			name:                 "Test ShortenURL without body",
 			requestBody:          "",
 			expectedStatus:       http.StatusBadRequest,
-			expectedResponseBody: "",
+			expectedResponseBody: problemBody(t, http.StatusBadRequest, problem.CodeInvalidRequest, "request body is missing", "/shorten"),
+		},
+		{
+			name:                 "Test ShortenURL normalizes URL",
+			requestBody:          " HTTPS://Practicum.Yandex.RU:443/learn\n",
+			expectedStatus:       http.StatusCreated,
+			expectedResponseBody: testCfg.BaseURL + "/" + utils.GenerateShortURL("https://practicum.yandex.ru/learn"),
+		},
+		{
+			name:                 "Test ShortenURL with javascript URL",
+			requestBody:          "javascript:alert(1)",
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: problemBody(t, http.StatusBadRequest, problem.CodeInvalidURL, `url "javascript:alert(1)": scheme must be http or https`, "/shorten"),
 		},
 	}
 
 	testStorage1 := newTestStorage()
-	testHandler1 := NewHandlerURL(testStorage1, testCfg.BaseURL)
+	testHandler1 := NewHandlerURL(testStorage1, testCfg.BaseURL, nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
 
 	testlog1 := logger.NewLogger()
 	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog1)
@@ -140,7 +222,7 @@ func TestShortenURL(t *testing.T) {
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
-			resultBody := w.Body.String()
+			resultBody := strings.TrimSpace(w.Body.String())
 			assert.Equal(t, tt.expectedResponseBody, resultBody)
 		})
 	}
@@ -152,7 +234,7 @@ func TestRedirectURL(t *testing.T) {
 	}
 
 	testStorage2 := newTestStorage()
-	testHandler2 := NewHandlerURL(testStorage2, testCfg.BaseURL)
+	testHandler2 := NewHandlerURL(testStorage2, testCfg.BaseURL, nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
 
 	testlog2 := logger.NewLogger()
 	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog2)
@@ -166,7 +248,7 @@ func TestRedirectURL(t *testing.T) {
 	testURL := "https://practicum.yandex.ru/"
 	shortURL := utils.GenerateShortURL(testURL)
 	userID := "test_user_id"
-	testStorage2.ShortenURL(context.TODO(), userID, testURL)
+	testStorage2.ShortenURL(context.TODO(), userID, testURL, models.ShortenOptions{})
 
 	tests := []struct {
 		name             string
@@ -186,7 +268,7 @@ func TestRedirectURL(t *testing.T) {
 			name:             "ShortURL not in UrlMap",
 			router:           r,
 			URL:              "invalidURL",
-			expectedStatus:   http.StatusGone,
+			expectedStatus:   http.StatusNotFound,
 			expectedLocation: "",
 		},
 	}
@@ -222,11 +304,35 @@ func TestJSONShortenURL(t *testing.T) {
 			name:                 "Test JSONShortenURL invalid JSON",
 			requestBody:          `invalid JSON`,
 			expectedStatus:       http.StatusBadRequest,
-			expectedResponseBody: "",
+			expectedResponseBody: problemBody(t, http.StatusBadRequest, problem.CodeInvalidJSON, "invalid JSON body", "/api/shorten"),
+		},
+		{
+			name:                 "Test JSONShortenURL with alias",
+			requestBody:          `{"url": "https://practicum.yandex.ru/spring", "alias": "spring-sale"}`,
+			expectedStatus:       http.StatusCreated,
+			expectedResponseBody: `{"result":"` + testCfg.BaseURL + `/spring-sale"}`,
+		},
+		{
+			name:                 "Test JSONShortenURL with taken alias",
+			requestBody:          `{"url": "https://practicum.yandex.ru/summer", "alias": "spring-sale"}`,
+			expectedStatus:       http.StatusConflict,
+			expectedResponseBody: problemBody(t, http.StatusConflict, problem.CodeAliasTaken, `alias "spring-sale" is already taken`, "/api/shorten"),
+		},
+		{
+			name:                 "Test JSONShortenURL with reserved alias",
+			requestBody:          `{"url": "https://practicum.yandex.ru/api", "alias": "api"}`,
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: problemBody(t, http.StatusBadRequest, problem.CodeInvalidAlias, `alias "api" is reserved`, "/api/shorten"),
+		},
+		{
+			name:                 "Test JSONShortenURL with relative URL",
+			requestBody:          `{"url": "practicum.yandex.ru"}`,
+			expectedStatus:       http.StatusBadRequest,
+			expectedResponseBody: problemBody(t, http.StatusBadRequest, problem.CodeInvalidURL, `url "practicum.yandex.ru" must be absolute and start with http:// or https://`, "/api/shorten"),
 		},
 	}
 	testStorage3 := newTestStorage()
-	testHandler3 := NewHandlerURL(testStorage3, testCfg.BaseURL)
+	testHandler3 := NewHandlerURL(testStorage3, testCfg.BaseURL, nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
 
 	testlog3 := logger.NewLogger()
 	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog3)
@@ -247,3 +353,254 @@ func TestJSONShortenURL(t *testing.T) {
 		})
 	}
 }
+
+// protectedStorage - тестовое хранилище с одной ссылкой, защищённой паролем
+type protectedStorage struct {
+	testStorage
+	password string
+}
+
+func (p *protectedStorage) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
+	switch opts.Password {
+	case "":
+		return "", storageErrors.ErrPasswordRequired
+	case p.password:
+		return "https://practicum.yandex.ru/", nil
+	default:
+		return "", storageErrors.ErrWrongPassword
+	}
+}
+
+func TestRedirectURLPassword(t *testing.T) {
+	testHandler := NewHandlerURL(&protectedStorage{password: "secret"}, "http://localhost:8081", nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
+
+	testlog := logger.NewLogger()
+	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog)
+
+	r := chi.NewRouter()
+	r.HandleFunc("/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		testHandler.RedirectURL(rw, r.WithContext(ctxLogger))
+	})
+
+	do := func(method, password, accept string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, "/protected", strings.NewReader("password="+password))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, "/protected", nil)
+			req.Header.Set(PasswordHeader, password)
+		}
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodGet, "", "text/html")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `LinkPassword realm="short link", header="X-Link-Password"`, rr.Header().Get("WWW-Authenticate"))
+	assert.Contains(t, rr.Body.String(), `<form method="POST" action="/protected">`)
+
+	rr = do(http.MethodGet, "", "application/json")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `LinkPassword realm="short link", header="X-Link-Password"`, rr.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+
+	rr = do(http.MethodGet, "secret", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+
+	rr = do(http.MethodPost, "secret", "text/html")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "https://practicum.yandex.ru/", rr.Header().Get("Location"))
+
+	for i := 0; i < utils.MaxPasswordAttempts; i++ {
+		rr = do(http.MethodPost, "wrong", "text/html")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	// после исчерпания попыток не проходит даже верный пароль
+	rr = do(http.MethodGet, "secret", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	// попытки считаются по адресам: владелец с другого адреса ссылку открывает
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set(PasswordHeader, "secret")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+}
+
+func TestWriteStoreError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{name: "not found", err: storageErrors.ErrNotFound, wantStatus: http.StatusNotFound, wantCode: problem.CodeNotFound, wantDetail: "URL not found"},
+		{name: "wrapped", err: fmt.Errorf("update: %w", storageErrors.ErrForbidden), wantStatus: http.StatusForbidden, wantCode: problem.CodeForbidden, wantDetail: "update: URL belongs to another user"},
+		{name: "expired", err: storageErrors.ErrExpired, wantStatus: http.StatusGone, wantCode: problem.CodeExpired, wantDetail: "URL has expired"},
+		{name: "deleted", err: storageErrors.ErrDeleted, wantStatus: http.StatusGone, wantCode: problem.CodeDeleted, wantDetail: "URL has been deleted"},
+		{name: "conflict", err: storageErrors.NewConflict("abc"), wantStatus: http.StatusConflict, wantCode: problem.CodeURLExists, wantDetail: "URL already in database"},
+		// ошибки базы данных опознаются по виду, а их текст клиенту не отдаётся
+		{name: "unavailable kind", err: fmt.Errorf("%w: dial tcp 10.0.0.5:5432: connection refused", storageErrors.ErrUnavailable), wantStatus: http.StatusServiceUnavailable, wantCode: problem.CodeUnavailable, wantDetail: "storage is temporarily unavailable"},
+		{name: "invalid kind", err: fmt.Errorf("%w: value too long", storageErrors.ErrInvalid), wantStatus: http.StatusBadRequest, wantCode: problem.CodeInvalidRequest, wantDetail: "invalid input"},
+		{name: "gone kind", err: storageErrors.ErrGone, wantStatus: http.StatusGone, wantCode: problem.CodeGone, wantDetail: "URL is no longer available"},
+		// текст неизвестной ошибки может раскрыть детали хранилища, поэтому клиенту он не отдаётся
+		{name: "unknown", err: errors.New("connection refused"), wantStatus: http.StatusInternalServerError, wantCode: problem.CodeInternal, wantDetail: "internal server error"},
+	}
+	testlog := logger.NewLogger()
+	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil).WithContext(ctxLogger)
+			rr := httptest.NewRecorder()
+			writeStoreError(rr, req, tt.err, "")
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+			var got problem.Details
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+			assert.Equal(t, tt.wantCode, got.Code)
+			assert.Equal(t, tt.wantDetail, got.Detail)
+			assert.Equal(t, tt.wantStatus, got.Status)
+		})
+	}
+}
+
+// slowProtectedStorage считает проверки пароля и имитирует медленное сравнение bcrypt
+type slowProtectedStorage struct {
+	protectedStorage
+	checks atomic.Int32
+}
+
+func (s *slowProtectedStorage) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
+	if opts.Password != "" {
+		s.checks.Add(1)
+		time.Sleep(20 * time.Millisecond)
+	}
+	return s.protectedStorage.RedirectURL(ctx, userID, shortURL, opts)
+}
+
+func TestRedirectURLPasswordConcurrent(t *testing.T) {
+	store := &slowProtectedStorage{protectedStorage: protectedStorage{password: "secret"}}
+	testHandler := NewHandlerURL(store, "http://localhost:8081", nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
+
+	testlog := logger.NewLogger()
+	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog)
+
+	r := chi.NewRouter()
+	r.HandleFunc("/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		testHandler.RedirectURL(rw, r.WithContext(ctxLogger))
+	})
+
+	const guesses = 4 * utils.MaxPasswordAttempts
+	var wg sync.WaitGroup
+	var limited atomic.Int32
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set(PasswordHeader, "wrong")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code == http.StatusTooManyRequests {
+				limited.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, utils.MaxPasswordAttempts, store.checks.Load(), "only the allowed number of guesses may reach the store")
+	assert.EqualValues(t, guesses-utils.MaxPasswordAttempts, limited.Load())
+}
+
+// failingBatchStorage - тестовое хранилище, которое отвергает любой пакет ошибкой err
+type failingBatchStorage struct {
+	testStorage
+	err error
+}
+
+func (f *failingBatchStorage) BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error) {
+	return nil, f.err
+}
+
+func TestBatchShortenURLError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{
+			name:       "conflict",
+			err:        &storageErrors.BatchError{Index: 1, Err: storageErrors.NewConflict("abc")},
+			wantStatus: http.StatusConflict,
+			wantCode:   problem.CodeURLExists,
+			wantDetail: `correlation_id "2": URL already in database; no URLs from the batch were saved`,
+		},
+		{
+			name:       "alias taken",
+			err:        &storageErrors.BatchError{Index: 0, Err: storageErrors.ErrAliasTaken},
+			wantStatus: http.StatusConflict,
+			wantCode:   problem.CodeAliasTaken,
+			wantDetail: `correlation_id "1": alias "first" is already taken; no URLs from the batch were saved`,
+		},
+		{
+			name:       "database error",
+			err:        &storageErrors.BatchError{Index: 1, Err: fmt.Errorf("%w: value too long", storageErrors.ErrInvalid)},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   problem.CodeInvalidRequest,
+			wantDetail: `correlation_id "2": invalid input; no URLs from the batch were saved`,
+		},
+	}
+	testlog := logger.NewLogger()
+	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog)
+	body := `[{"correlation_id": "1", "original_url": "https://practicum.yandex.ru/", "alias": "first"},
+		{"correlation_id": "2", "original_url": "https://yandex.ru/"}]`
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHandler := NewHandlerURL(&failingBatchStorage{err: tt.err}, "http://localhost:8081", nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)).WithContext(ctxLogger)
+			rr := httptest.NewRecorder()
+			testHandler.BatchShortenURL(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			var got problem.Details
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+			assert.Equal(t, tt.wantCode, got.Code)
+			assert.Equal(t, tt.wantDetail, got.Detail)
+		})
+	}
+}
+
+// downStorage - тестовое хранилище, до которого нельзя достучаться
+type downStorage struct {
+	testStorage
+}
+
+func (d *downStorage) Ping(ctx context.Context) error {
+	return fmt.Errorf("%w: dial tcp 10.0.0.5:5432: connection refused", storageErrors.ErrUnavailable)
+}
+
+func TestPingUnavailable(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctxLogger := logger.ContextWithLogger(context.Background(), &testlog)
+	testHandler := NewHandlerURL(&downStorage{}, "http://localhost:8081", nil, nil, time.Hour, utils.URLNormalizer{}, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil).WithContext(ctxLogger)
+	rr := httptest.NewRecorder()
+	testHandler.Ping(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	var got problem.Details
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Equal(t, problem.CodeUnavailable, got.Code)
+	assert.NotContains(t, got.Detail, "10.0.0.5")
+}
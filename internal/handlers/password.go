@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/problem"
+)
+
+// PasswordHeader - заголовок, в котором API-клиенты передают пароль защищённой ссылки
+const PasswordHeader = "X-Link-Password"
+
+// passwordAuthChallenge - значение WWW-Authenticate в ответе 401 на защищённую ссылку.
+// RFC 9110 требует этот заголовок у каждого ответа 401; схема LinkPassword сообщает,
+// что пароль передаётся в заголовке PasswordHeader или полем формы.
+const passwordAuthChallenge = `LinkPassword realm="short link", header="` + PasswordHeader + `"`
+
+var passwordFormTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Password required</title></head>
+<body>
+<form method="POST" action="/{{.ShortURL}}">
+<p>This link is password protected.</p>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// wantsHTML сообщает, что запрос пришёл из браузера, а не от API-клиента
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodPost || strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// passwordChallenge просит ввести пароль: браузеру отдаётся HTML-форма,
+// API-клиенту - ошибка с кодом code в формате problem+json
+func passwordChallenge(rw http.ResponseWriter, r *http.Request, shortURL, code, message string) {
+	log := logger.LoggerFromContext(r.Context())
+	rw.Header().Set("WWW-Authenticate", passwordAuthChallenge)
+	if !wantsHTML(r) {
+		problem.Write(rw, r, http.StatusUnauthorized, code, message+": send it in the "+PasswordHeader+" header")
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusUnauthorized)
+	data := struct{ ShortURL, Message string }{ShortURL: shortURL}
+	if r.Method == http.MethodPost {
+		data.Message = message
+	}
+	if err := passwordFormTemplate.Execute(rw, data); err != nil {
+		log.Errorf("Password form render error %s", err)
+	}
+}
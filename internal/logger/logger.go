@@ -6,14 +6,20 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type ctxLogger struct{}
 
 var sugar zap.SugaredLogger
 
+// level - уровень логирования, который можно менять без пересоздания логгера
+var level = zap.NewAtomicLevel()
+
 func NewLogger() zap.SugaredLogger {
-	logger, err := zap.NewProduction()
+	cfg := zap.NewProductionConfig()
+	cfg.Level = level
+	logger, err := cfg.Build()
 	if err != nil {
 		panic(err)
 	}
@@ -22,6 +28,16 @@ func NewLogger() zap.SugaredLogger {
 	return sugar
 }
 
+// SetLevel меняет уровень логирования всех логгеров, созданных NewLogger
+func SetLevel(text string) error {
+	l, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(l)
+	return nil
+}
+
 // ContextWithLogger adds logger to context
 func ContextWithLogger(ctx context.Context, l *zap.SugaredLogger) context.Context {
 	return context.WithValue(ctx, ctxLogger{}, l)
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upShortURLUnique, downShortURLUnique)
+}
+
+func upShortURLUnique(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	// До появления индекса разные URL могли получить одинаковый short_url.
+	// Самая ранняя запись сохраняет код, остальным выдаётся код с суффиксом id,
+	// чтобы уже выданная ссылка не стала вести на другой адрес.
+	query := `
+	UPDATE shortener SET short_url = short_url || '-' || id
+	WHERE id NOT IN (SELECT MIN(id) FROM shortener GROUP BY short_url);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS short_url_unique ON shortener(short_url);
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downShortURLUnique(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `DROP INDEX IF EXISTS short_url_unique;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
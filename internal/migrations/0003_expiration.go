@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upExpiration, downExpiration)
+}
+
+func upExpiration(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `
+	ALTER TABLE shortener ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+	ALTER TABLE shortener ADD COLUMN IF NOT EXISTS is_expired BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE INDEX IF NOT EXISTS shortener_expires_at ON shortener(expires_at)
+		WHERE expires_at IS NOT NULL AND is_expired = FALSE;
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downExpiration(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `
+	DROP INDEX IF EXISTS shortener_expires_at;
+	ALTER TABLE shortener DROP COLUMN IF EXISTS is_expired;
+	ALTER TABLE shortener DROP COLUMN IF EXISTS expires_at;
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
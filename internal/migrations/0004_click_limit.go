@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upClickLimit, downClickLimit)
+}
+
+func upClickLimit(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `ALTER TABLE shortener ADD COLUMN IF NOT EXISTS clicks_left INTEGER CHECK (clicks_left >= 0);`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downClickLimit(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `ALTER TABLE shortener DROP COLUMN IF EXISTS clicks_left;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
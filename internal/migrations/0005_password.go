@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upPassword, downPassword)
+}
+
+func upPassword(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `ALTER TABLE shortener ADD COLUMN IF NOT EXISTS password_hash TEXT;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downPassword(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `ALTER TABLE shortener DROP COLUMN IF EXISTS password_hash;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upClicks, downClicks)
+}
+
+func upClicks(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `
+	CREATE TABLE IF NOT EXISTS clicks (
+		id BIGSERIAL PRIMARY KEY,
+		short_url TEXT NOT NULL,
+		clicked_at TIMESTAMPTZ NOT NULL,
+		referrer TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS clicks_short_url_clicked_at ON clicks(short_url, clicked_at);
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downClicks(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `DROP TABLE clicks;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
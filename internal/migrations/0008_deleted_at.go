@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upDeletedAt, downDeletedAt)
+}
+
+func upDeletedAt(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `
+	ALTER TABLE shortener ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+	UPDATE shortener SET deleted_at = now() WHERE is_deleted = true AND deleted_at IS NULL;
+
+	CREATE INDEX IF NOT EXISTS shortener_deleted_at ON shortener(deleted_at) WHERE is_deleted = true;
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downDeletedAt(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `
+	DROP INDEX IF EXISTS shortener_deleted_at;
+	ALTER TABLE shortener DROP COLUMN IF EXISTS deleted_at;
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
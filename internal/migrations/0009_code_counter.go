@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upCodeCounter, downCodeCounter)
+}
+
+func upCodeCounter(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	// Таблица из одной строки хранит наибольшее выданное значение счётчика генератора кодов,
+	// чтобы коды окончательно удалённых ссылок не выдавались повторно. Начальное значение -
+	// прежняя оценка счётчика по наибольшему id.
+	query := `
+	CREATE TABLE IF NOT EXISTS code_counter (
+		id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+		value BIGINT NOT NULL DEFAULT 0
+	);
+
+	INSERT INTO code_counter(id, value)
+	SELECT TRUE, COALESCE(MAX(id), 0) FROM shortener
+	ON CONFLICT (id) DO NOTHING;
+	`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func downCodeCounter(ctx context.Context, tx *sql.Tx) error {
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+	query := `DROP TABLE IF EXISTS code_counter;`
+	_, err := tx.ExecContext(ctrl, query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,7 +1,17 @@
 package models
 
+import "time"
+
 type JSONShortenURLRequest struct {
-	URL string `json:"url"`
+	URL   string `json:"url"`
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt и TTL задают срок действия ссылки: момент времени или длительность вида "24h"
+	ExpiresAt time.Time `json:"expires_at"`
+	TTL       string    `json:"ttl,omitempty"`
+	// MaxClicks ограничивает число переходов по ссылке; 0 - без ограничений
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// Password - пароль, который нужно ввести перед переходом по ссылке
+	Password string `json:"password,omitempty"`
 }
 
 type JSONShortenURLResponse struct {
@@ -9,8 +19,13 @@ type JSONShortenURLResponse struct {
 }
 
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string    `json:"correlation_id"`
+	OriginalURL   string    `json:"original_url"`
+	Alias         string    `json:"alias,omitempty"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	TTL           string    `json:"ttl,omitempty"`
+	MaxClicks     int       `json:"max_clicks,omitempty"`
+	Password      string    `json:"password,omitempty"`
 }
 
 type BatchResponse struct {
@@ -18,8 +33,120 @@ type BatchResponse struct {
 	ShortURL      string `json:"short_url"`
 }
 
+// Event описывает запись о сокращённой ссылке в файловом хранилище
 type Event struct {
 	UserID      string `json:"user_id"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	DeletedFlag bool   `json:"is_deleted,omitempty"`
+	// DeletedAt - момент удаления; до окончательной очистки ссылку можно восстановить
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ExpiredFlag выставляется фоновой очисткой после истечения ExpiresAt
+	ExpiredFlag bool `json:"is_expired,omitempty"`
+	// ClicksLeft - сколько переходов по ссылке осталось; nil - без ограничений
+	ClicksLeft *int `json:"clicks_left,omitempty"`
+	// PasswordHash - bcrypt-хеш пароля ссылки; пустая строка - ссылка без пароля
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Revisions - прежние значения оригинального URL в порядке изменения
+	Revisions []Revision `json:"revisions,omitempty"`
+	// Purged отмечает запись журнала файлового хранилища об окончательном удалении ссылки
+	Purged bool `json:"purged,omitempty"`
+	// CodeCounter - значение счётчика генератора кодов на момент создания ссылки.
+	// Запись файлового хранилища без ShortURL хранит только счётчик и ссылкой не является.
+	CodeCounter uint64 `json:"code_counter,omitempty"`
+}
+
+// Revision - прежнее значение оригинального URL ссылки
+type Revision struct {
+	OriginalURL string    `json:"original_url"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// Expired сообщает, истёк ли срок действия ссылки к моменту now
+func (e Event) Expired(now time.Time) bool {
+	return e.ExpiredFlag || (e.ExpiresAt != nil && !now.Before(*e.ExpiresAt))
+}
+
+// ShortenOptions содержит необязательные параметры сокращения URL
+type ShortenOptions struct {
+	// Alias - желаемый короткий код вместо сгенерированного
+	Alias string
+	// ExpiresAt - момент, после которого ссылка перестаёт работать; нулевое значение - бессрочно
+	ExpiresAt time.Time
+	// MaxClicks - сколько раз можно перейти по ссылке; 0 - без ограничений
+	MaxClicks int
+	// PasswordHash - bcrypt-хеш пароля, который нужно ввести перед переходом
+	PasswordHash string
+}
+
+// BatchItem - ссылка из пакетного запроса на сокращение
+type BatchItem struct {
+	OriginalURL string
+	Options     ShortenOptions
+}
+
+// RedirectOptions содержит параметры перехода по короткой ссылке
+type RedirectOptions struct {
+	// Password - пароль защищённой ссылки
+	Password string
+}
+
+// UserURL описывает ссылку в списке URL пользователя
+type UserURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Expired     bool       `json:"is_expired,omitempty"`
+	ClicksLeft  *int       `json:"clicks_left,omitempty"`
+	Protected   bool       `json:"password_protected,omitempty"`
+}
+
+// EditURLRequest - тело запроса на изменение оригинального URL ссылки
+type EditURLRequest struct {
+	URL string `json:"url"`
+}
+
+// EditURLResponse описывает ссылку после изменения вместе с историей правок
+type EditURLResponse struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Revisions   []Revision `json:"revisions"`
+}
+
+// ListOptions содержит параметры выборки URL пользователя
+type ListOptions struct {
+	// IncludeExpired включает в выборку ссылки с истёкшим сроком действия
+	IncludeExpired bool
+}
+
+// Click описывает переход по короткой ссылке
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// IP - адрес клиента с обнулёнными младшими битами
+	IP string `json:"ip,omitempty"`
+}
+
+// StatsBucket - число переходов за интервал, начинающийся в Start
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// ServiceStats - сводная статистика сервиса
+type ServiceStats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// URLStats - статистика переходов по ссылке
+type URLStats struct {
+	ShortURL       string        `json:"short_url"`
+	TotalClicks    int           `json:"total_clicks"`
+	UniqueVisitors int           `json:"unique_visitors"`
+	Bucket         string        `json:"bucket"`
+	Buckets        []StatsBucket `json:"buckets"`
 }
@@ -0,0 +1,68 @@
+// Package problem формирует ответы с ошибками в формате RFC 7807 (application/problem+json).
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ContentType - тип содержимого ответа с ошибкой
+const ContentType = "application/problem+json"
+
+// Коды ошибок в поле code ответа. Клиенты разбирают ошибки по ним,
+// поэтому однажды выпущенный код не меняется.
+const (
+	CodeInvalidJSON       = "invalid_json"
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidURL        = "invalid_url"
+	CodeInvalidAlias      = "invalid_alias"
+	CodeDuplicateAlias    = "duplicate_alias"
+	CodeInvalidExpiration = "invalid_expiration"
+	CodeInvalidMaxClicks  = "invalid_max_clicks"
+	CodeInvalidPassword   = "invalid_password"
+	CodeInvalidBucket     = "invalid_bucket"
+	CodeInvalidEncoding   = "invalid_encoding"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeGone              = "gone"
+	CodeDeleted           = "deleted"
+	CodeExpired           = "expired"
+	CodeClicksExhausted   = "clicks_exhausted"
+	CodePasswordRequired  = "password_required"
+	CodeWrongPassword     = "wrong_password"
+	CodeTooManyAttempts   = "too_many_attempts"
+	CodeURLExists         = "url_exists"
+	CodeAliasTaken        = "alias_taken"
+	CodeConflict          = "conflict"
+	CodeQueueFull         = "queue_full"
+	CodeUnavailable       = "unavailable"
+	CodeInternal          = "internal"
+)
+
+// Details - тело ответа с ошибкой. Code - расширение RFC 7807
+// со стабильным машинно-читаемым кодом ошибки.
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// Write отправляет ответ с ошибкой status; detail - понятное человеку пояснение
+func Write(rw http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	rw.Header().Set("Content-Type", ContentType)
+	rw.Header().Del("Content-Length")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(Details{
+		// Отдельных страниц с описанием ошибок нет, поэтому тип - about:blank, а различаются они по Code
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"errors"
+)
+
+// Close ничего не делает: хранилищу в памяти нечего освобождать
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// Close сбрасывает на диск и закрывает файлы журнала и переходов.
+// После Close сжатие журнала больше не выполняется.
+func (r *repoURL) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	var errs []error
+	if err := r.file.Sync(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := r.file.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+	if err := r.clicksFile.Sync(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := r.clicksFile.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Close закрывает пул соединений, дожидаясь возврата всех соединений
+func (s *Database) Close() error {
+	s.db.Close()
+	return nil
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+)
+
+// snapshotPath возвращает путь к файлу снимка для журнала filename
+func snapshotPath(filename string) string {
+	return filename + ".snapshot"
+}
+
+// runCompaction периодически сжимает журнал, пока не отменён ctx
+func (r *repoURL) runCompaction(ctx context.Context, interval time.Duration) {
+	log := logger.LoggerFromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Compact(ctx); err != nil {
+				log.Errorf("error Compact %s", err)
+			}
+		}
+	}
+}
+
+// Compact переписывает состояние хранилища в снимок без повторных записей
+// и начинает новый пустой журнал. Удалённые записи остаются в снимке
+// до окончательной очистки. Снимок и журнал заменяются атомарно через
+// запись во временный файл, fsync и rename, поэтому сбой на любом шаге
+// оставляет на диске согласованную пару снимок + журнал.
+func (r *repoURL) Compact(ctx context.Context) error {
+	return r.compact(ctx, false)
+}
+
+// compact выполняет сжатие; без force пропускает его, если журнал пуст
+func (r *repoURL) compact(ctx context.Context, force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || (r.logRecords == 0 && !force) {
+		return nil
+	}
+	return r.compactLocked(ctx)
+}
+
+// compactLocked - compact для вызывающего, который уже держит r.mu
+func (r *repoURL) compactLocked(ctx context.Context) error {
+	log := logger.LoggerFromContext(ctx)
+	events := r.URLMap.snapshot()
+	// Счётчик генератора записывается первым: в снимке нет окончательно удалённых
+	// ссылок, и без этой записи их коды после перезапуска выдались бы снова
+	if counter, ok := r.URLMap.counterRecord(); ok {
+		events = append([]models.Event{counter}, events...)
+	}
+
+	if err := r.writeSnapshot(events); err != nil {
+		return err
+	}
+	// Снимок уже содержит всё из журнала, поэтому журнал можно начать заново.
+	// Если процесс упадёт до замены журнала, его записи применятся поверх снимка
+	// повторно и ничего не изменят: каждая запись задаёт состояние ссылки целиком,
+	// а окончательно удалённые ссылки убирают записи об удалении в конце журнала.
+	if err := r.resetLog(); err != nil {
+		return err
+	}
+
+	log.Infow(
+		"File storage compacted",
+		"log records", r.logRecords,
+		"snapshot records", len(events),
+	)
+	r.logRecords = 0
+	return nil
+}
+
+// writeSnapshot атомарно заменяет снимок записями events
+func (r *repoURL) writeSnapshot(events []models.Event) error {
+	return writeFileAtomic(snapshotPath(r.filename), func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		for _, e := range events {
+			record, err := encodeRecord(e)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// resetLog атомарно заменяет журнал пустым и переоткрывает его для дозаписи
+func (r *repoURL) resetLog() error {
+	if err := writeFileAtomic(r.filename, func(*os.File) error { return nil }); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.filename, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	r.file.Close()
+	r.file = file
+	return nil
+}
+
+// writeFileAtomic записывает файл во временный файл рядом с name,
+// синхронизирует его на диск и переименовывает в name
+func writeFileAtomic(name string, write func(f *os.File) error) error {
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir синхронизирует каталог, чтобы переименование пережило сбой питания
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
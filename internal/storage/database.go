@@ -17,10 +17,11 @@ import (
 )
 
 type Database struct {
-	db *pgxpool.Pool
+	db  *pgxpool.Pool
+	gen utils.CodeGenerator
 }
 
-func NewDBStore(databaseAddress string, ctx context.Context) (*Database, error) {
+func NewDBStore(databaseAddress string, gen utils.CodeGenerator, ctx context.Context) (*Database, error) {
 	log := logger.LoggerFromContext(ctx)
 
 	// Открываем соединение для миграции
@@ -46,41 +47,145 @@ func NewDBStore(databaseAddress string, ctx context.Context) (*Database, error)
 		return nil, err
 	}
 
+	// Продвигаем счётчик генератора за все когда-либо выданные значения,
+	// включая коды окончательно удалённых ссылок
+	var counter int64
+	if err := db.QueryRow(ctx, `SELECT value FROM code_counter`).Scan(&counter); err != nil {
+		log.Errorf("error select code counter: %s", err)
+		return nil, err
+	}
+	utils.SeedGenerator(gen, uint64(counter))
+
 	d := &Database{
-		db: db,
+		db:  db,
+		gen: gen,
 	}
 	return d, nil
 }
 
-func (s *Database) ShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+// execer - общий интерфейс пула соединений и транзакции для выполнения запросов
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// insertURL сохраняет URL под запрошенным псевдонимом или первым свободным коротким кодом.
+// Занятый short_url не вызывает ошибку благодаря ON CONFLICT, поэтому
+// перебор кодов не прерывает транзакцию; конфликт по original_url возвращается как ошибка.
+func insertURL(ctx context.Context, db execer, gen utils.CodeGenerator, userID, originalURL string, opts models.ShortenOptions) (string, error) {
+	for attempt := 0; attempt < utils.MaxShortURLAttempts; attempt++ {
+		shortURL := opts.Alias
+		if shortURL == "" {
+			var err error
+			shortURL, err = gen.Generate(originalURL, attempt)
+			if err != nil {
+				return "", err
+			}
+			if utils.IsReserved(shortURL) {
+				continue
+			}
+		}
+		event := newEvent(userID, shortURL, originalURL, opts)
+		// Счётчик генератора сохраняется тем же запросом, что и ссылка,
+		// поэтому после перезапуска выданный код не достанется другой ссылке
+		var counter int64
+		if opts.Alias == "" {
+			counter = int64(utils.GeneratorCounter(gen))
+		}
+		var inserted bool
+		err := db.QueryRow(ctx,
+			`WITH inserted AS (
+				INSERT INTO shortener(short_url, original_url, user_id, expires_at, clicks_left, password_hash)
+				VALUES($1, $2, $3, $4, $5, NULLIF($6, ''))
+				ON CONFLICT (short_url) DO NOTHING
+				RETURNING id
+			), counter AS (
+				UPDATE code_counter SET value = $7
+				WHERE value < $7 AND EXISTS (SELECT 1 FROM inserted)
+			)
+			SELECT EXISTS (SELECT 1 FROM inserted)`,
+			shortURL, originalURL, userID, event.ExpiresAt, event.ClicksLeft, event.PasswordHash, counter).Scan(&inserted)
+		if err != nil {
+			return "", err
+		}
+		if inserted {
+			return shortURL, nil
+		}
+		if opts.Alias != "" {
+			return "", storageErrors.ErrAliasTaken
+		}
+	}
+	return "", storageErrors.ErrCollision
+}
+
+func (s *Database) ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error) {
 	log := logger.LoggerFromContext(ctx)
-	shortURL := utils.GenerateShortURL(originalURL)
 
-	_, err := s.db.Exec(ctx, `INSERT INTO shortener(short_url, original_url, user_id) VALUES($1, $2, $3)`, shortURL, originalURL, userID)
+	shortURL, err := insertURL(ctx, s.db, s.gen, userID, originalURL, opts)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			var res string
-			s.db.QueryRow(ctx, `SELECT short_url FROM shortener WHERE original_url = $1`, originalURL).Scan(&res)
-			return res, storageErrors.ErrUnique
+		if isUniqueViolation(err) {
+			return s.conflict(ctx, originalURL, err)
 		}
 		log.Errorf("error ExecContext %s", err)
-		return "", err
+		return "", dbError(err)
 	}
 
-	return shortURL, err
+	return shortURL, nil
+}
+
+// isUniqueViolation сообщает, нарушено ли ограничение уникальности
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
 }
 
-func (s *Database) RedirectURL(ctx context.Context, userID, shortURL string) (string, error) {
-	var originalURL string
+// conflict возвращает код ссылки, под которой originalURL уже сохранён, и ConflictError с ним.
+// Если найти её не удалось, возвращается исходная ошибка нарушения уникальности.
+func (s *Database) conflict(ctx context.Context, originalURL string, err error) (string, error) {
+	var existing string
+	if s.db.QueryRow(ctx, `SELECT short_url FROM shortener WHERE original_url = $1`, originalURL).Scan(&existing) != nil {
+		return "", dbError(err)
+	}
+	return existing, storageErrors.NewConflict(existing)
+}
+
+func (s *Database) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
+	var e models.Event
 	log := logger.LoggerFromContext(ctx)
 
-	row := s.db.QueryRow(ctx, `SELECT original_url FROM shortener WHERE short_url = $1 AND is_deleted = false`, shortURL)
-	if err := row.Scan(&originalURL); err != nil {
-		log.Errorf("row.Scan error", err)
-		return "", err
+	row := s.db.QueryRow(ctx, `SELECT original_url, expires_at, is_expired, clicks_left, COALESCE(password_hash, ''), is_deleted
+		FROM shortener WHERE short_url = $1`, shortURL)
+	if err := row.Scan(&e.OriginalURL, &e.ExpiresAt, &e.ExpiredFlag, &e.ClicksLeft, &e.PasswordHash, &e.DeletedFlag); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", storageErrors.ErrNotFound
+		}
+		log.Errorf("row.Scan error %s", err)
+		return "", dbError(err)
 	}
-	return originalURL, nil
+	if e.DeletedFlag {
+		return "", storageErrors.ErrDeleted
+	}
+	if err := checkAccess(e, opts); err != nil {
+		return "", dbError(err)
+	}
+	if e.ClicksLeft == nil {
+		return e.OriginalURL, nil
+	}
+
+	// Условное уменьшение атомарно: при одновременных переходах
+	// строку обновят не больше раз, чем осталось переходов
+	err := s.db.QueryRow(ctx,
+		`UPDATE shortener SET clicks_left = clicks_left - 1
+		WHERE short_url = $1 AND is_deleted = false AND clicks_left > 0
+		RETURNING original_url`, shortURL).Scan(&e.OriginalURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", storageErrors.ErrClicksExhausted
+	}
+	if err != nil {
+		log.Errorf("error consume click %s", err)
+		return "", dbError(err)
+	}
+	return e.OriginalURL, nil
 }
 
 func (s *Database) Ping(ctx context.Context) error {
@@ -88,72 +193,102 @@ func (s *Database) Ping(ctx context.Context) error {
 	err := s.db.Ping(ctx)
 	if err != nil {
 		log.Errorf("error PingContext %s", err)
-		return err
+		return dbError(err)
 	}
 	return nil
 }
 
-func (s *Database) BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+// BatchShortenURL сокращает пакет URL в одной транзакции: если хотя бы одну ссылку
+// сохранить нельзя, не сохраняется ни одна
+func (s *Database) BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error) {
 	log := logger.LoggerFromContext(ctx)
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		log.Errorf("error Begin() %s", err)
-		return "", err
+		return nil, dbError(err)
 	}
 	defer tx.Rollback(ctx)
 
-	shortURL := utils.GenerateShortURL(originalURL)
-	_, err = tx.Exec(ctx, "INSERT INTO shortener(short_url, original_url, user_id) VALUES($1, $2, $3)", shortURL, originalURL, userID)
-	if err != nil {
-		log.Errorf("error ExecContext %s", err)
-		return "", err
+	shortURLs := make([]string, 0, len(items))
+	originals := make(map[string]bool, len(items))
+	for i, item := range items {
+		if originals[item.OriginalURL] {
+			return nil, &storageErrors.BatchError{Index: i, Err: storageErrors.ErrBatchDuplicate}
+		}
+		originals[item.OriginalURL] = true
+
+		shortURL, err := insertURL(ctx, tx, s.gen, userID, item.OriginalURL, item.Options)
+		if err != nil {
+			if isUniqueViolation(err) {
+				// Транзакция прервана ошибкой, поэтому существующую ссылку ищем вне её
+				_, err = s.conflict(ctx, item.OriginalURL, err)
+			} else {
+				log.Errorf("error ExecContext %s", err)
+				err = dbError(err)
+			}
+			return nil, &storageErrors.BatchError{Index: i, Err: err}
+		}
+		shortURLs = append(shortURLs, shortURL)
 	}
-	return shortURL, tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		log.Errorf("error Commit() %s", err)
+		return nil, dbError(err)
+	}
+	return shortURLs, nil
 }
 
-func (s *Database) GetUserURLs(ctx context.Context, userID string, baseURL string) ([]models.Event, error) {
+func (s *Database) GetUserURLs(ctx context.Context, userID string, baseURL string, opts models.ListOptions) ([]models.Event, error) {
 	log := logger.LoggerFromContext(ctx)
 	var events []models.Event
 
-	rows, err := s.db.Query(ctx, `SELECT short_url, original_url FROM shortener WHERE user_id = $1`, userID)
+	rows, err := s.db.Query(ctx, `SELECT short_url, original_url, expires_at, is_expired, clicks_left, COALESCE(password_hash, '') FROM shortener
+		WHERE user_id = $1 AND ($2 OR (is_expired = false AND (expires_at IS NULL OR expires_at > now())))`,
+		userID, opts.IncludeExpired)
 	if err != nil {
 		log.Errorf("QueryContext error", err)
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var e models.Event
-		if err := rows.Scan(&e.ShortURL, &e.OriginalURL); err != nil {
+		if err := rows.Scan(&e.ShortURL, &e.OriginalURL, &e.ExpiresAt, &e.ExpiredFlag, &e.ClicksLeft, &e.PasswordHash); err != nil {
 			log.Errorf("Scan error", err)
-			return nil, err
+			return nil, dbError(err)
 		}
 		e.ShortURL = baseURL + "/" + e.ShortURL
 		events = append(events, e)
 	}
 	if err := rows.Err(); err != nil {
 		log.Errorf("rows.Err()", err)
-		return nil, err
+		return nil, dbError(err)
 	}
 	return events, nil
 }
 
-func (s *Database) DeleteUserURLs(ctx context.Context, userID string, urls []string) error {
+// DeleteUserURLs помечает URL пользователя как удалённые одним запросом
+// и возвращает число помеченных ссылок
+func (s *Database) DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error) {
 	log := logger.LoggerFromContext(ctx)
-	log.Info("Start DeleteUserURLs in database.go")
 
-	batch := pgx.Batch{}
-	for _, url := range urls {
-		batch.Queue("UPDATE shortener SET is_deleted = true WHERE short_url = $1 AND user_id = $2;", url, userID)
+	tag, err := s.db.Exec(ctx,
+		`UPDATE shortener SET is_deleted = true, deleted_at = now()
+		WHERE user_id = $1 AND short_url = ANY($2) AND is_deleted = false`,
+		userID, urls)
+	if err != nil {
+		log.Errorf("error DeleteUserURLs %s", err)
+		return 0, dbError(err)
 	}
+	return int(tag.RowsAffected()), nil
+}
 
-	br := s.db.SendBatch(context.Background(), &batch)
-	err := br.Close()
+// SweepExpired помечает просроченные ссылки и возвращает их количество
+func (s *Database) SweepExpired(ctx context.Context) (int, error) {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE shortener SET is_expired = true
+		WHERE is_expired = false AND expires_at IS NOT NULL AND expires_at <= now()`)
 	if err != nil {
-		log.Errorf("SendBatch error", err)
-		return err
+		return 0, dbError(err)
 	}
-
-	log.Info("End DeleteUserURLs in database.go")
-	return err
+	return int(tag.RowsAffected()), nil
 }
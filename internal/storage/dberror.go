@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// storageKinds - виды ошибок хранилища; ошибки, уже относящиеся к ним, dbError не меняет
+var storageKinds = []error{
+	storageErrors.ErrNotFound,
+	storageErrors.ErrGone,
+	storageErrors.ErrConflict,
+	storageErrors.ErrInvalid,
+	storageErrors.ErrUnavailable,
+	storageErrors.ErrPermissionDenied,
+}
+
+// dbError относит ошибку PostgreSQL или соединения с ним к одному из видов ошибок хранилища,
+// сохраняя исходную ошибку в цепочке. Ошибки, которые не удалось отнести ни к одному
+// виду, возвращаются без изменений.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range storageKinds {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+	if kind := dbErrorKind(err); kind != nil {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return err
+}
+
+// dbErrorKind возвращает вид ошибки PostgreSQL или nil, если вид не определён
+func dbErrorKind(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storageErrors.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgerrcode.UniqueViolation:
+			return storageErrors.ErrConflict
+		case pgerrcode.IsDataException(pgErr.Code), pgerrcode.IsIntegrityConstraintViolation(pgErr.Code):
+			return storageErrors.ErrInvalid
+		case pgerrcode.IsConnectionException(pgErr.Code),
+			pgerrcode.IsInsufficientResources(pgErr.Code),
+			pgerrcode.IsOperatorIntervention(pgErr.Code),
+			pgerrcode.IsTransactionRollback(pgErr.Code):
+			return storageErrors.ErrUnavailable
+		}
+		return nil
+	}
+
+	// Ошибка без кода PostgreSQL означает, что запрос не дошёл до сервера или ответ не пришёл
+	var netErr net.Error
+	if errors.As(err, &netErr) || pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return storageErrors.ErrUnavailable
+	}
+	return nil
+}
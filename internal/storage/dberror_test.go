@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"no rows", fmt.Errorf("select: %w", pgx.ErrNoRows), storageErrors.ErrNotFound},
+		{"unique violation", &pgconn.PgError{Code: pgerrcode.UniqueViolation}, storageErrors.ErrConflict},
+		{"not null violation", &pgconn.PgError{Code: pgerrcode.NotNullViolation}, storageErrors.ErrInvalid},
+		{"value too long", &pgconn.PgError{Code: pgerrcode.StringDataRightTruncationDataException}, storageErrors.ErrInvalid},
+		{"connection failure", &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, storageErrors.ErrUnavailable},
+		{"too many connections", &pgconn.PgError{Code: pgerrcode.TooManyConnections}, storageErrors.ErrUnavailable},
+		{"admin shutdown", &pgconn.PgError{Code: pgerrcode.AdminShutdown}, storageErrors.ErrUnavailable},
+		{"serialization failure", &pgconn.PgError{Code: pgerrcode.SerializationFailure}, storageErrors.ErrUnavailable},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, storageErrors.ErrUnavailable},
+		{"already classified", storageErrors.ErrExpired, storageErrors.ErrGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dbError(tt.err)
+			assert.ErrorIs(t, err, tt.want)
+			assert.ErrorIs(t, err, tt.err, "original error must stay in the chain")
+		})
+	}
+
+	syntax := &pgconn.PgError{Code: pgerrcode.SyntaxError}
+	assert.Same(t, syntax, dbError(syntax), "unknown errors are returned as is")
+	assert.NoError(t, dbError(nil))
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/jackc/pgx/v5"
+)
+
+// editedEvent возвращает запись с новым оригинальным URL и прежним значением в истории правок.
+// changed равен false, если URL не изменился.
+// Вызывающий должен держать indexMu, чтобы оригинальный URL не заняли между проверкой и записью.
+func (m *memoryStore) editedEvent(userID, shortURL, originalURL string, now time.Time) (event models.Event, changed bool, err error) {
+	event, err = m.getActive(shortURL)
+	if err != nil {
+		return event, false, err
+	}
+	if event.UserID != userID {
+		return event, false, storageErrors.ErrForbidden
+	}
+	if event.OriginalURL == originalURL {
+		return event, false, nil
+	}
+	// Оригинальный URL уникален: нельзя перенаправить ссылку на адрес другой ссылки
+	if existing, taken := m.originals[originalURL]; taken {
+		return event, false, storageErrors.NewConflict(existing)
+	}
+
+	event.Revisions = append(append([]models.Revision(nil), event.Revisions...), models.Revision{
+		OriginalURL: event.OriginalURL,
+		ChangedAt:   now.UTC(),
+	})
+	event.OriginalURL = originalURL
+	return event, true, nil
+}
+
+// UpdateURL меняет оригинальный URL ссылки её владельца
+func (m *memoryStore) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	event, changed, err := m.editedEvent(userID, shortURL, originalURL, time.Now())
+	if err != nil || !changed {
+		return event, err
+	}
+	m.applyLocked(event)
+	return event, nil
+}
+
+// UpdateURL меняет оригинальный URL ссылки её владельца и сохраняет правку в файл
+func (r *repoURL) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error) {
+	log := logger.LoggerFromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// r.mu не даёт другим записям занять оригинальный URL между проверкой и сохранением
+	r.URLMap.indexMu.RLock()
+	event, changed, err := r.URLMap.editedEvent(userID, shortURL, originalURL, time.Now())
+	r.URLMap.indexMu.RUnlock()
+	if err != nil || !changed {
+		return event, err
+	}
+	if err := r.persist(event); err != nil {
+		log.Errorf("error persist event %s", err)
+		return models.Event{}, err
+	}
+	r.URLMap.apply(event)
+	return event, nil
+}
+
+// UpdateURL меняет оригинальный URL ссылки её владельца, записывая прежнее значение
+// в таблицу url_revisions в той же транзакции
+func (s *Database) UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error) {
+	log := logger.LoggerFromContext(ctx)
+	event := models.Event{ShortURL: shortURL}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Errorf("error Begin() %s", err)
+		return event, dbError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var deleted bool
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(user_id, ''), original_url, is_deleted FROM shortener WHERE short_url = $1 FOR UPDATE`,
+		shortURL).Scan(&event.UserID, &event.OriginalURL, &deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return event, storageErrors.ErrNotFound
+	}
+	if err != nil {
+		return event, dbError(err)
+	}
+	if deleted {
+		return event, storageErrors.ErrDeleted
+	}
+	if event.UserID != userID {
+		return event, storageErrors.ErrForbidden
+	}
+
+	if event.OriginalURL != originalURL {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO url_revisions (short_url, original_url, changed_at) VALUES ($1, $2, now())`,
+			shortURL, event.OriginalURL)
+		if err != nil {
+			return event, dbError(err)
+		}
+		_, err = tx.Exec(ctx, `UPDATE shortener SET original_url = $2 WHERE short_url = $1`, shortURL, originalURL)
+		if isUniqueViolation(err) {
+			_, err = s.conflict(ctx, originalURL, err)
+			return event, err
+		}
+		if err != nil {
+			return event, dbError(err)
+		}
+		event.OriginalURL = originalURL
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT original_url, changed_at FROM url_revisions WHERE short_url = $1 ORDER BY id`, shortURL)
+	if err != nil {
+		return event, dbError(err)
+	}
+	event.Revisions, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Revision, error) {
+		var rev models.Revision
+		err := row.Scan(&rev.OriginalURL, &rev.ChangedAt)
+		return rev, err
+	})
+	if err != nil {
+		return event, dbError(err)
+	}
+	return event, dbError(tx.Commit(ctx))
+}
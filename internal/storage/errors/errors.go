@@ -1,5 +1,114 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
-var ErrUnique = errors.New("URL already in database")
+// Виды ошибок хранилища. Каждая ошибка, которую возвращают хранилища, относится
+// к одному из видов и оборачивает его, поэтому вызывающий код может проверить вид
+// через errors.Is, не перечисляя отдельные ошибки.
+var (
+	// ErrNotFound возвращается, если короткой ссылки нет в хранилище
+	ErrNotFound = errors.New("URL not found")
+	// ErrGone возвращается, если ссылка была, но больше недоступна
+	ErrGone = errors.New("URL is no longer available")
+	// ErrConflict возвращается, если запись противоречит уже сохранённой
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid возвращается, если хранилище отвергло входные данные
+	ErrInvalid = errors.New("invalid input")
+	// ErrUnavailable возвращается при временном сбое хранилища; запрос можно повторить
+	ErrUnavailable = errors.New("storage is temporarily unavailable")
+	// ErrPermissionDenied возвращается, если действие со ссылкой не разрешено
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// kindError - ошибка хранилища с собственным текстом, относящаяся к виду kind
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+// newError создает ошибку вида kind с текстом msg
+func newError(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+// ErrUnique возвращается, если оригинальный URL уже сокращён.
+// Хранилища возвращают её в составе ConflictError с существующим коротким кодом.
+var ErrUnique = newError(ErrConflict, "URL already in database")
+
+// ErrCollision возвращается, если не удалось подобрать свободный короткий URL
+var ErrCollision = newError(ErrUnavailable, "could not generate a unique short URL")
+
+// ErrAliasTaken возвращается, если запрошенный короткий код уже занят
+var ErrAliasTaken = newError(ErrConflict, "alias is already taken")
+
+// ErrBatchDuplicate возвращается, если оригинальный URL повторяется в одном пакете
+var ErrBatchDuplicate = newError(ErrConflict, "URL is repeated in the batch")
+
+// ErrDeleted возвращается при обращении к удалённой ссылке
+var ErrDeleted = newError(ErrGone, "URL has been deleted")
+
+// ErrExpired возвращается при обращении к ссылке с истёкшим сроком действия
+var ErrExpired = newError(ErrGone, "URL has expired")
+
+// ErrClicksExhausted возвращается, если лимит переходов по ссылке исчерпан
+var ErrClicksExhausted = newError(ErrGone, "URL click limit reached")
+
+// ErrPasswordRequired возвращается при переходе по защищённой ссылке без пароля
+var ErrPasswordRequired = newError(ErrPermissionDenied, "URL is password protected")
+
+// ErrWrongPassword возвращается при переходе по защищённой ссылке с неверным паролем
+var ErrWrongPassword = newError(ErrPermissionDenied, "wrong URL password")
+
+// ErrForbidden возвращается при обращении к чужой ссылке
+var ErrForbidden = newError(ErrPermissionDenied, "URL belongs to another user")
+
+// ConflictError возвращается, если оригинальный URL уже сокращён;
+// ShortURL - код существующей ссылки. errors.Is(err, ErrUnique) для неё истинно.
+type ConflictError struct {
+	ShortURL string
+}
+
+func (e *ConflictError) Error() string { return ErrUnique.Error() }
+
+func (e *ConflictError) Unwrap() error { return ErrUnique }
+
+// NewConflict возвращает ConflictError для существующей ссылки shortURL
+func NewConflict(shortURL string) error {
+	return &ConflictError{ShortURL: shortURL}
+}
+
+// ExistingShortURL возвращает код существующей ссылки из ConflictError в цепочке err
+func ExistingShortURL(err error) (string, bool) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return conflict.ShortURL, true
+	}
+	return "", false
+}
+
+// BatchError возвращается, если пакет ссылок не сохранён из-за элемента с номером Index;
+// Err - ошибка этого элемента. Пакет сохраняется целиком или не сохраняется вовсе.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string { return fmt.Sprintf("batch item %d: %s", e.Index, e.Err) }
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// BatchIndex возвращает номер элемента пакета из BatchError в цепочке err
+func BatchIndex(err error) (int, bool) {
+	var batch *BatchError
+	if errors.As(err, &batch) {
+		return batch.Index, true
+	}
+	return 0, false
+}
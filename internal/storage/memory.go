@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/utils"
+)
+
+// shardCount - количество сегментов, на которые разбиты записи memoryStore
+const shardCount = 32
+
+// memoryShard - сегмент записей со своей блокировкой
+type memoryShard struct {
+	mu   sync.RWMutex
+	urls map[string]*models.Event
+}
+
+// memoryStore - потокобезопасное хранилище URL в памяти, реализующее интерфейс URLStore.
+// Записи разбиты на сегменты по хешу короткого URL, поэтому редирект блокирует
+// только свой сегмент на чтение и не ждёт изменений в остальных.
+type memoryStore struct {
+	shards [shardCount]*memoryShard
+
+	// indexMu защищает индексы по оригинальному URL и по пользователю
+	indexMu   sync.RWMutex
+	originals map[string]string
+	userURLs  map[string][]string
+
+	gen utils.CodeGenerator
+	// codeCounter - наибольшее значение счётчика генератора среди применённых записей,
+	// в том числе окончательно удалённых; защищён indexMu
+	codeCounter uint64
+
+	// clicks - переходы по ссылкам для статистики
+	clicksMu sync.Mutex
+	clicks   map[string][]models.Click
+}
+
+// NewMemoryStore создает новый экземпляр хранилища в памяти
+func NewMemoryStore(gen utils.CodeGenerator) URLStore {
+	return newMemoryStore(gen)
+}
+
+func newMemoryStore(gen utils.CodeGenerator) *memoryStore {
+	m := &memoryStore{
+		originals: make(map[string]string),
+		userURLs:  make(map[string][]string),
+		gen:       gen,
+		clicks:    make(map[string][]models.Click),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{urls: make(map[string]*models.Event)}
+	}
+	return m
+}
+
+// shard возвращает сегмент, в котором хранится короткий URL
+func (m *memoryStore) shard(shortURL string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(shortURL))
+	return m.shards[h.Sum32()%shardCount]
+}
+
+// get возвращает копию записи по короткому URL
+func (m *memoryStore) get(shortURL string) (models.Event, bool) {
+	s := m.shard(shortURL)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.urls[shortURL]
+	if !ok {
+		return models.Event{}, false
+	}
+	return *e, true
+}
+
+// lookupOriginal возвращает короткий URL, уже выданный для оригинального URL
+func (m *memoryStore) lookupOriginal(originalURL string) (string, bool) {
+	m.indexMu.RLock()
+	defer m.indexMu.RUnlock()
+	shortURL, ok := m.originals[originalURL]
+	return shortURL, ok
+}
+
+// apply применяет запись к состоянию хранилища: последняя запись для short_url побеждает,
+// а запись об окончательном удалении убирает ссылку
+func (m *memoryStore) apply(event models.Event) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+	m.applyLocked(event)
+}
+
+// applyLocked - apply для вызывающего, который уже держит indexMu
+func (m *memoryStore) applyLocked(event models.Event) {
+	m.codeCounter = max(m.codeCounter, event.CodeCounter)
+	if event.ShortURL == "" {
+		// запись только о счётчике генератора
+		return
+	}
+	if event.Purged {
+		m.removeLocked(event.ShortURL)
+		return
+	}
+	s := m.shard(event.ShortURL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.urls[event.ShortURL]; ok {
+		if current.OriginalURL != event.OriginalURL {
+			delete(m.originals, current.OriginalURL)
+		}
+		*current = event
+	} else {
+		e := event
+		s.urls[event.ShortURL] = &e
+		m.userURLs[event.UserID] = append(m.userURLs[event.UserID], event.ShortURL)
+	}
+	m.originals[event.OriginalURL] = event.ShortURL
+}
+
+// ShortenURL сокращает оригинальный URL и сохраняет его в памяти
+func (m *memoryStore) ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	if shortURL, ok := m.originals[originalURL]; ok {
+		return shortURL, storageErrors.NewConflict(shortURL)
+	}
+	shortURL, err := m.pickShortURL(originalURL, opts, nil)
+	if err != nil {
+		return "", err
+	}
+	m.applyLocked(m.newEvent(userID, shortURL, originalURL, opts))
+	return shortURL, nil
+}
+
+// planBatch подбирает коды для пакета items, ничего не сохраняя, и возвращает записи
+// о новых ссылках. Вызывающий должен держать indexMu, чтобы коды не заняли до записи.
+func (m *memoryStore) planBatch(userID string, items []models.BatchItem) ([]models.Event, error) {
+	events := make([]models.Event, 0, len(items))
+	taken := make(map[string]bool, len(items))
+	originals := make(map[string]bool, len(items))
+	for i, item := range items {
+		if shortURL, ok := m.originals[item.OriginalURL]; ok {
+			return nil, &storageErrors.BatchError{Index: i, Err: storageErrors.NewConflict(shortURL)}
+		}
+		if originals[item.OriginalURL] {
+			return nil, &storageErrors.BatchError{Index: i, Err: storageErrors.ErrBatchDuplicate}
+		}
+		shortURL, err := m.pickShortURL(item.OriginalURL, item.Options, taken)
+		if err != nil {
+			return nil, &storageErrors.BatchError{Index: i, Err: err}
+		}
+		originals[item.OriginalURL] = true
+		taken[shortURL] = true
+		events = append(events, m.newEvent(userID, shortURL, item.OriginalURL, item.Options))
+	}
+	return events, nil
+}
+
+// counterRecord возвращает запись о счётчике генератора или false, если счётчик не выдавался
+func (m *memoryStore) counterRecord() (models.Event, bool) {
+	m.indexMu.RLock()
+	defer m.indexMu.RUnlock()
+	return models.Event{CodeCounter: m.codeCounter}, m.codeCounter > 0
+}
+
+// shortURLs возвращает коды ссылок из записей events
+func shortURLs(events []models.Event) []string {
+	codes := make([]string, len(events))
+	for i, e := range events {
+		codes[i] = e.ShortURL
+	}
+	return codes
+}
+
+// newEvent - newEvent для только что подобранного кода: запись запоминает счётчик генератора,
+// чтобы после перезапуска он не выдал этот код снова
+func (m *memoryStore) newEvent(userID, shortURL, originalURL string, opts models.ShortenOptions) models.Event {
+	event := newEvent(userID, shortURL, originalURL, opts)
+	event.CodeCounter = utils.GeneratorCounter(m.gen)
+	return event
+}
+
+// newEvent создает запись о новой ссылке с учётом параметров сокращения
+func newEvent(userID, shortURL, originalURL string, opts models.ShortenOptions) models.Event {
+	event := models.Event{
+		UserID:      userID,
+		ShortURL:    shortURL,
+		OriginalURL: originalURL,
+	}
+	if !opts.ExpiresAt.IsZero() {
+		expiresAt := opts.ExpiresAt.UTC()
+		event.ExpiresAt = &expiresAt
+	}
+	if opts.MaxClicks > 0 {
+		clicksLeft := opts.MaxClicks
+		event.ClicksLeft = &clicksLeft
+	}
+	event.PasswordHash = opts.PasswordHash
+	return event
+}
+
+// checkAccess проверяет, можно ли перейти по ссылке: не истёк ли срок и подходит ли пароль.
+// Лимит переходов проверяется отдельно при списании перехода.
+func checkAccess(event models.Event, opts models.RedirectOptions) error {
+	if event.Expired(time.Now()) {
+		return storageErrors.ErrExpired
+	}
+	if event.PasswordHash == "" {
+		return nil
+	}
+	if opts.Password == "" {
+		return storageErrors.ErrPasswordRequired
+	}
+	if !utils.CheckPassword(event.PasswordHash, opts.Password) {
+		return storageErrors.ErrWrongPassword
+	}
+	return nil
+}
+
+// getActive возвращает запись о ссылке, если она есть и не удалена
+func (m *memoryStore) getActive(shortURL string) (models.Event, error) {
+	event, ok := m.get(shortURL)
+	if !ok {
+		return event, storageErrors.ErrNotFound
+	}
+	if event.DeletedFlag {
+		return event, storageErrors.ErrDeleted
+	}
+	return event, nil
+}
+
+// withClickConsumed возвращает запись с уменьшенным на один переход остатком
+// или ErrClicksExhausted, если переходов не осталось
+func withClickConsumed(event models.Event) (models.Event, error) {
+	if event.ClicksLeft == nil {
+		return event, nil
+	}
+	if *event.ClicksLeft <= 0 {
+		return event, storageErrors.ErrClicksExhausted
+	}
+	clicksLeft := *event.ClicksLeft - 1
+	event.ClicksLeft = &clicksLeft
+	return event, nil
+}
+
+// pickShortURL возвращает запрошенный псевдоним, если он свободен, или подбирает код генератором.
+// Коды из taken считаются занятыми, даже если их ещё нет в хранилище.
+func (m *memoryStore) pickShortURL(originalURL string, opts models.ShortenOptions, taken map[string]bool) (string, error) {
+	if opts.Alias == "" {
+		return m.freeShortURL(originalURL, taken)
+	}
+	if _, ok := m.get(opts.Alias); ok || taken[opts.Alias] {
+		return "", storageErrors.ErrAliasTaken
+	}
+	return opts.Alias, nil
+}
+
+// freeShortURL подбирает короткий URL, ещё не занятый другой ссылкой и не входящий в taken.
+// Вызывающий должен держать indexMu, чтобы код не заняли между проверкой и записью.
+func (m *memoryStore) freeShortURL(originalURL string, taken map[string]bool) (string, error) {
+	for attempt := 0; attempt < utils.MaxShortURLAttempts; attempt++ {
+		shortURL, err := m.gen.Generate(originalURL, attempt)
+		if err != nil {
+			return "", err
+		}
+		if utils.IsReserved(shortURL) {
+			continue
+		}
+		if _, ok := m.get(shortURL); !ok && !taken[shortURL] {
+			return shortURL, nil
+		}
+	}
+	return "", storageErrors.ErrCollision
+}
+
+// RedirectURL возвращает оригинальный URL, списывая переход у ссылок с лимитом
+func (m *memoryStore) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
+	log := logger.LoggerFromContext(ctx)
+	event, err := m.getActive(shortURL)
+	if err != nil {
+		log.Errorf("error memoryStore get(shortURL) %s", err)
+		return "", err
+	}
+	if err := checkAccess(event, opts); err != nil {
+		return "", err
+	}
+	if event.ClicksLeft != nil {
+		return m.consumeClick(shortURL)
+	}
+	return event.OriginalURL, nil
+}
+
+// consumeClick списывает переход под блокировкой сегмента, поэтому
+// одновременные переходы не могут превысить лимит
+func (m *memoryStore) consumeClick(shortURL string) (string, error) {
+	s := m.shard(shortURL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.urls[shortURL]
+	if !ok {
+		return "", storageErrors.ErrNotFound
+	}
+	if e.DeletedFlag {
+		return "", storageErrors.ErrDeleted
+	}
+	consumed, err := withClickConsumed(*e)
+	if err != nil {
+		return "", err
+	}
+	*e = consumed
+	return e.OriginalURL, nil
+}
+
+// BatchShortenURL сокращает пакет URL целиком: если хотя бы одну ссылку сохранить
+// нельзя, не сохраняется ни одна
+func (m *memoryStore) BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	events, err := m.planBatch(userID, items)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		m.applyLocked(event)
+	}
+	return shortURLs(events), nil
+}
+
+func (m *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// GetUserURLs возвращает все URL, сокращённые пользователем
+func (m *memoryStore) GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error) {
+	m.indexMu.RLock()
+	shortURLs := append([]string(nil), m.userURLs[userID]...)
+	m.indexMu.RUnlock()
+
+	now := time.Now()
+	events := make([]models.Event, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		e, ok := m.get(shortURL)
+		if !ok || (!opts.IncludeExpired && e.Expired(now)) {
+			continue
+		}
+		e.ShortURL = baseURL + "/" + e.ShortURL
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// DeleteUserURLs помечает URL пользователя как удалённые и возвращает число помеченных ссылок
+func (m *memoryStore) DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error) {
+	now := time.Now().UTC()
+	deleted := 0
+	for _, shortURL := range urls {
+		s := m.shard(shortURL)
+		s.mu.Lock()
+		if e, ok := s.urls[shortURL]; ok && e.UserID == userID && !e.DeletedFlag {
+			e.DeletedFlag = true
+			e.DeletedAt = &now
+			deleted++
+		}
+		s.mu.Unlock()
+	}
+	return deleted, nil
+}
+
+// expiredCandidates возвращает копии просроченных к now записей, ещё не помеченных
+// как просроченные, с уже выставленной пометкой
+func (m *memoryStore) expiredCandidates(now time.Time) []models.Event {
+	var events []models.Event
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for _, e := range s.urls {
+			if !e.ExpiredFlag && !e.DeletedFlag && e.Expired(now) {
+				expired := *e
+				expired.ExpiredFlag = true
+				events = append(events, expired)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return events
+}
+
+// SweepExpired помечает просроченные ссылки и возвращает их количество
+func (m *memoryStore) SweepExpired(ctx context.Context) (int, error) {
+	events := m.expiredCandidates(time.Now())
+	for _, e := range events {
+		m.apply(e)
+	}
+	return len(events), nil
+}
+
+// snapshot возвращает копии всех записей, сохраняя порядок URL каждого пользователя
+func (m *memoryStore) snapshot() []models.Event {
+	m.indexMu.RLock()
+	defer m.indexMu.RUnlock()
+
+	var events []models.Event
+	for _, shortURLs := range m.userURLs {
+		for _, shortURL := range shortURLs {
+			if e, ok := m.get(shortURL); ok {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
+
+// count возвращает количество записей, включая удалённые
+func (m *memoryStore) count() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.RLock()
+		n += len(s.urls)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// remove полностью удаляет запись из хранилища
+func (m *memoryStore) remove(shortURL string) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+	m.removeLocked(shortURL)
+}
+
+// removeLocked - remove для вызывающего, который уже держит indexMu
+func (m *memoryStore) removeLocked(shortURL string) {
+	s := m.shard(shortURL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.urls[shortURL]
+	if !ok {
+		return
+	}
+	delete(s.urls, shortURL)
+	if m.originals[e.OriginalURL] == shortURL {
+		delete(m.originals, e.OriginalURL)
+	}
+	userURLs := m.userURLs[e.UserID]
+	for i, u := range userURLs {
+		if u == shortURL {
+			m.userURLs[e.UserID] = append(userURLs[:i:i], userURLs[i+1:]...)
+			break
+		}
+	}
+	if len(m.userURLs[e.UserID]) == 0 {
+		delete(m.userURLs, e.UserID)
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := NewMemoryStore(utils.HashGenerator{})
+
+	shortURL, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	dup, err := store.ShortenURL(ctx, "user2", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrUnique)
+	assert.ErrorIs(t, err, storageErrors.ErrConflict)
+	assert.Equal(t, shortURL, dup)
+	existing, ok := storageErrors.ExistingShortURL(err)
+	assert.True(t, ok)
+	assert.Equal(t, shortURL, existing)
+
+	_, err = store.RedirectURL(ctx, "", "missing", models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrNotFound)
+
+	url, err := store.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", url)
+
+	events, err := store.GetUserURLs(ctx, "user1", "http://localhost:8080", models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "http://localhost:8080/"+shortURL, events[0].ShortURL)
+
+	deleted, err := store.DeleteUserURLs(ctx, "user2", []string{shortURL})
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+	_, err = store.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+	assert.NoError(t, err, "only the owner may delete a URL")
+
+	deleted, err = store.DeleteUserURLs(ctx, "user1", []string{shortURL, "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = store.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrDeleted)
+	assert.ErrorIs(t, err, storageErrors.ErrGone)
+
+	// повторное удаление уже удалённой ссылки не считается
+	deleted, err = store.DeleteUserURLs(ctx, "user1", []string{shortURL})
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestMemoryStoreConcurrent(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := NewMemoryStore(utils.HashGenerator{})
+
+	const workers = 16
+	const perWorker = 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user%d", w)
+			for i := 0; i < perWorker; i++ {
+				// половина URL общая для всех воркеров, чтобы проверить гонку за уникальность
+				originalURL := fmt.Sprintf("https://example.com/%d/%d", w%2, i)
+				shortURL, err := store.ShortenURL(ctx, userID, originalURL, models.ShortenOptions{})
+				if err != nil && !errors.Is(err, storageErrors.ErrUnique) {
+					t.Errorf("ShortenURL: %s", err)
+					return
+				}
+				if _, err := store.RedirectURL(ctx, userID, shortURL, models.RedirectOptions{}); err != nil {
+					t.Errorf("RedirectURL: %s", err)
+				}
+				if _, err := store.GetUserURLs(ctx, userID, "", models.ListOptions{}); err != nil {
+					t.Errorf("GetUserURLs: %s", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0
+	for w := 0; w < workers; w++ {
+		events, err := store.GetUserURLs(ctx, fmt.Sprintf("user%d", w), "", models.ListOptions{})
+		require.NoError(t, err)
+		total += len(events)
+	}
+	assert.Equal(t, 2*perWorker, total)
+}
+
+func TestMemoryStoreCollision(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := newMemoryStore(utils.HashGenerator{})
+
+	// занимаем код, который получил бы новый URL, другой ссылкой
+	taken := utils.GenerateShortURL("https://practicum.yandex.ru/")
+	store.apply(models.Event{UserID: "user1", ShortURL: taken, OriginalURL: "https://yandex.ru/"})
+
+	shortURL, err := store.ShortenURL(ctx, "user2", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, taken, shortURL)
+
+	url, err := store.RedirectURL(ctx, "", taken, models.RedirectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru/", url)
+
+	url, err = store.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", url)
+}
+
+// fixedGenerator выдаёт заранее заданные коды по номеру попытки
+type fixedGenerator []string
+
+func (g fixedGenerator) Generate(originalURL string, attempt int) (string, error) {
+	return g[attempt%len(g)], nil
+}
+
+func TestMemoryStoreSkipsReservedCodes(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := NewMemoryStore(fixedGenerator{"ping", "API", "abc"})
+
+	shortURL, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", shortURL, "codes shadowed by service routes must be skipped")
+
+	_, err = store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrCollision)
+}
+
+func TestMemoryStoreExpiration(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := NewMemoryStore(utils.HashGenerator{})
+
+	expired, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/",
+		models.ShortenOptions{ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	active, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/",
+		models.ShortenOptions{ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	_, err = store.RedirectURL(ctx, "", expired, models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrExpired)
+	_, err = store.RedirectURL(ctx, "", active, models.RedirectOptions{})
+	assert.NoError(t, err)
+
+	n, err := store.SweepExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	events, err := store.GetUserURLs(ctx, "user1", "", models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "/"+active, events[0].ShortURL)
+
+	events, err = store.GetUserURLs(ctx, "user1", "", models.ListOptions{IncludeExpired: true})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestMemoryStoreStats(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	store := NewMemoryStore(utils.HashGenerator{})
+
+	shortURL, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	require.NoError(t, store.RecordClicks(ctx, []models.Click{
+		{ShortURL: shortURL, Time: day, IP: "10.0.0.0", UserAgent: "a"},
+		{ShortURL: shortURL, Time: day.Add(time.Minute), IP: "10.0.0.0", UserAgent: "a"},
+		{ShortURL: shortURL, Time: day.Add(2 * time.Hour), IP: "10.0.1.0", UserAgent: "a"},
+		{ShortURL: shortURL, Time: day.Add(24 * time.Hour), IP: "10.0.0.0", UserAgent: "b"},
+	}))
+
+	stats, err := store.GetURLStats(ctx, "user1", shortURL, BucketDay)
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.TotalClicks)
+	assert.Equal(t, 3, stats.UniqueVisitors)
+	require.Len(t, stats.Buckets, 2)
+	assert.Equal(t, 3, stats.Buckets[0].Clicks)
+
+	stats, err = store.GetURLStats(ctx, "user1", shortURL, BucketHour)
+	require.NoError(t, err)
+	assert.Len(t, stats.Buckets, 3)
+
+	_, err = store.GetURLStats(ctx, "user2", shortURL, BucketDay)
+	assert.ErrorIs(t, err, storageErrors.ErrForbidden)
+	_, err = store.GetURLStats(ctx, "user1", "missing", BucketDay)
+	assert.ErrorIs(t, err, storageErrors.ErrNotFound)
+}
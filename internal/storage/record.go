@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	"github.com/11Petrov/urlshortener/internal/models"
+)
+
+// Формат записи файлового хранилища - одна строка на запись:
+//
+//	<длина JSON, 8 hex> <CRC32-C JSON, 8 hex> <JSON>\n
+//
+// JSON не содержит переводов строки, поэтому строка остаётся границей записи
+// даже при испорченном заголовке, а длина и контрольная сумма позволяют
+// отличить целую запись от оборванной или повреждённой.
+const recordHeaderLen = 8 + 1 + 8 + 1
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// encodeRecord кодирует запись в строку журнала с длиной и контрольной суммой
+func encodeRecord(event models.Event) ([]byte, error) {
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, recordHeaderLen+len(payload)+1)
+	buf = fmt.Appendf(buf, "%08x %08x ", len(payload), crc32.Checksum(payload, crcTable))
+	buf = append(buf, payload...)
+	return append(buf, '\n'), nil
+}
+
+// decodeRecord разбирает строку журнала без завершающего перевода строки.
+// Строки без заголовка, начинающиеся с '{', читаются как записи старого формата.
+func decodeRecord(line []byte) (models.Event, bool, error) {
+	var event models.Event
+	if len(line) > 0 && line[0] == '{' {
+		if err := json.Unmarshal(line, &event); err != nil {
+			return event, true, fmt.Errorf("%w: %s", errCorruptRecord, err)
+		}
+		return event, true, nil
+	}
+
+	if len(line) < recordHeaderLen || line[8] != ' ' || line[17] != ' ' {
+		return event, false, fmt.Errorf("%w: malformed header", errCorruptRecord)
+	}
+	length, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return event, false, fmt.Errorf("%w: bad length: %s", errCorruptRecord, err)
+	}
+	sum, err := strconv.ParseUint(string(line[9:17]), 16, 32)
+	if err != nil {
+		return event, false, fmt.Errorf("%w: bad checksum: %s", errCorruptRecord, err)
+	}
+	payload := line[recordHeaderLen:]
+	if uint64(len(payload)) != length {
+		return event, false, fmt.Errorf("%w: length %d, want %d", errCorruptRecord, len(payload), length)
+	}
+	if crc32.Checksum(payload, crcTable) != uint32(sum) {
+		return event, false, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, false, fmt.Errorf("%w: %s", errCorruptRecord, err)
+	}
+	return event, false, nil
+}
+
+// recoveryReport описывает результат чтения файла хранилища
+type recoveryReport struct {
+	Records     int
+	Legacy      int
+	Quarantined int
+	// TornOffset - смещение оборванной последней записи, -1 если её нет
+	TornOffset int64
+}
+
+// readRecords читает записи из r и передаёт целые записи в apply,
+// а повреждённые строки - в quarantine. Оборванная последняя строка
+// не передаётся никуда: её смещение сохраняется в отчёте.
+func readRecords(r io.Reader, apply func(models.Event), quarantine func(line []byte)) (recoveryReport, error) {
+	report := recoveryReport{TornOffset: -1}
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				report.TornOffset = offset
+			}
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		offset += int64(len(line))
+
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		event, legacy, err := decodeRecord(line)
+		if err != nil {
+			report.Quarantined++
+			quarantine(line)
+			continue
+		}
+		if legacy {
+			report.Legacy++
+		}
+		report.Records++
+		apply(event)
+	}
+}
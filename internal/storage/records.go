@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/11Petrov/urlshortener/internal/models"
+)
+
+// RecordStore - хранилище, из которого можно выгрузить записи и в которое можно
+// загрузить их как есть, с исходным коротким URL, владельцем и пометкой удаления.
+// Используется для переноса данных между бэкендами.
+type RecordStore interface {
+	// ExportRecords передаёт в fn все записи с short_url больше after в порядке возрастания short_url
+	ExportRecords(ctx context.Context, after string, fn func(models.Event) error) error
+	// ImportRecord сохраняет запись и возвращает false, если такой короткий или оригинальный URL уже есть
+	ImportRecord(ctx context.Context, event models.Event) (bool, error)
+	// HasRecord сообщает, будет ли запись пропущена при импорте как дубликат
+	HasRecord(ctx context.Context, event models.Event) (bool, error)
+	// Close освобождает ресурсы хранилища
+	Close() error
+}
+
+// ExportRecords передаёт в fn записи хранилища в порядке возрастания short_url
+func (m *memoryStore) ExportRecords(ctx context.Context, after string, fn func(models.Event) error) error {
+	events := m.snapshot()
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ShortURL < events[j].ShortURL
+	})
+	for _, e := range events {
+		if e.ShortURL <= after {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasRecord сообщает, есть ли уже в хранилище короткий или оригинальный URL записи
+func (m *memoryStore) HasRecord(ctx context.Context, event models.Event) (bool, error) {
+	if _, ok := m.get(event.ShortURL); ok {
+		return true, nil
+	}
+	_, ok := m.lookupOriginal(event.OriginalURL)
+	return ok, nil
+}
+
+// ImportRecord сохраняет запись в памяти, если она не дубликат
+func (m *memoryStore) ImportRecord(ctx context.Context, event models.Event) (bool, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	if _, ok := m.originals[event.OriginalURL]; ok {
+		return false, nil
+	}
+	if _, ok := m.get(event.ShortURL); ok {
+		return false, nil
+	}
+	m.applyLocked(event)
+	return true, nil
+}
+
+// ExportRecords передаёт в fn записи файлового хранилища в порядке возрастания short_url
+func (r *repoURL) ExportRecords(ctx context.Context, after string, fn func(models.Event) error) error {
+	return r.URLMap.ExportRecords(ctx, after, fn)
+}
+
+// HasRecord сообщает, есть ли уже в хранилище короткий или оригинальный URL записи
+func (r *repoURL) HasRecord(ctx context.Context, event models.Event) (bool, error) {
+	return r.URLMap.HasRecord(ctx, event)
+}
+
+// ImportRecord сохраняет запись в файл, если она не дубликат
+func (r *repoURL) ImportRecord(ctx context.Context, event models.Event) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if exists, _ := r.URLMap.HasRecord(ctx, event); exists {
+		return false, nil
+	}
+	if err := r.persist(event); err != nil {
+		return false, err
+	}
+	r.URLMap.apply(event)
+	return true, nil
+}
+
+// ExportRecords построчно передаёт в fn записи таблицы в порядке возрастания short_url
+// вместе с историей правок
+func (s *Database) ExportRecords(ctx context.Context, after string, fn func(models.Event) error) error {
+	rows, err := s.db.Query(ctx,
+		`SELECT short_url, original_url, COALESCE(user_id, ''), is_deleted, expires_at, is_expired, clicks_left,
+		COALESCE(password_hash, ''), deleted_at,
+		(SELECT json_agg(json_build_object('original_url', r.original_url, 'changed_at', r.changed_at) ORDER BY r.id)
+			FROM url_revisions r WHERE r.short_url = shortener.short_url)
+		FROM shortener
+		WHERE short_url > $1 ORDER BY short_url`, after)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.Event
+		var revisions []byte
+		if err := rows.Scan(&e.ShortURL, &e.OriginalURL, &e.UserID, &e.DeletedFlag, &e.ExpiresAt, &e.ExpiredFlag, &e.ClicksLeft, &e.PasswordHash, &e.DeletedAt, &revisions); err != nil {
+			return err
+		}
+		if revisions != nil {
+			if err := json.Unmarshal(revisions, &e.Revisions); err != nil {
+				return err
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// HasRecord сообщает, есть ли уже в таблице короткий или оригинальный URL записи
+func (s *Database) HasRecord(ctx context.Context, event models.Event) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM shortener WHERE short_url = $1 OR original_url = $2)`,
+		event.ShortURL, event.OriginalURL).Scan(&exists)
+	return exists, err
+}
+
+// ImportRecord вставляет запись в таблицу вместе с историей правок, если она не дубликат
+func (s *Database) ImportRecord(ctx context.Context, event models.Event) (bool, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO shortener(short_url, original_url, user_id, is_deleted, expires_at, is_expired, clicks_left, password_hash, deleted_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9
+		WHERE NOT EXISTS (SELECT 1 FROM shortener WHERE short_url = $1)
+		ON CONFLICT DO NOTHING`,
+		event.ShortURL, event.OriginalURL, event.UserID, event.DeletedFlag, event.ExpiresAt, event.ExpiredFlag, event.ClicksLeft, event.PasswordHash, event.DeletedAt)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() != 1 {
+		return false, nil
+	}
+	for _, rev := range event.Revisions {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO url_revisions (short_url, original_url, changed_at) VALUES ($1, $2, $3)`,
+			event.ShortURL, rev.OriginalURL, rev.ChangedAt)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit(ctx)
+}
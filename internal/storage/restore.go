@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+)
+
+// purgeLockID - ключ advisory-блокировки Postgres, под которой выполняется очистка.
+// Блокировка не даёт нескольким экземплярам сервиса удалять записи одновременно.
+const purgeLockID = 0x75726c70 // "urlp"
+
+// restorable сообщает, может ли пользователь восстановить запись, удалённую не раньше deletedAfter
+func restorable(e models.Event, userID string, deletedAfter time.Time) bool {
+	return e.DeletedFlag && e.UserID == userID && e.DeletedAt != nil && !e.DeletedAt.Before(deletedAfter)
+}
+
+// purgeable сообщает, пора ли окончательно удалить запись.
+// Записи, удалённые до появления deleted_at, удаляются при первой очистке.
+func purgeable(e models.Event, deletedBefore time.Time) bool {
+	return e.DeletedFlag && (e.DeletedAt == nil || e.DeletedAt.Before(deletedBefore))
+}
+
+// restoredCandidates возвращает копии записей пользователя, которые можно восстановить,
+// с уже снятой пометкой удаления
+func (m *memoryStore) restoredCandidates(userID string, urls []string, deletedAfter time.Time) []models.Event {
+	var events []models.Event
+	for _, shortURL := range urls {
+		e, ok := m.get(shortURL)
+		if !ok || !restorable(e, userID, deletedAfter) {
+			continue
+		}
+		e.DeletedFlag = false
+		e.DeletedAt = nil
+		events = append(events, e)
+	}
+	return events
+}
+
+// purgeCandidates возвращает короткие URL записей, которые пора окончательно удалить
+func (m *memoryStore) purgeCandidates(deletedBefore time.Time) []string {
+	var shortURLs []string
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for shortURL, e := range s.urls {
+			if purgeable(*e, deletedBefore) {
+				shortURLs = append(shortURLs, shortURL)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return shortURLs
+}
+
+// RestoreUserURLs снимает пометку удаления с URL пользователя, удалённых не раньше deletedAfter,
+// и возвращает восстановленные короткие URL
+func (m *memoryStore) RestoreUserURLs(ctx context.Context, userID string, urls []string, deletedAfter time.Time) ([]string, error) {
+	restored := []string{}
+	for _, shortURL := range urls {
+		s := m.shard(shortURL)
+		s.mu.Lock()
+		if e, ok := s.urls[shortURL]; ok && restorable(*e, userID, deletedAfter) {
+			e.DeletedFlag = false
+			e.DeletedAt = nil
+			restored = append(restored, shortURL)
+		}
+		s.mu.Unlock()
+	}
+	return restored, nil
+}
+
+// PurgeDeleted окончательно удаляет записи, удалённые раньше deletedBefore, вместе с их переходами
+func (m *memoryStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	shortURLs := m.purgeCandidates(deletedBefore)
+	m.purge(shortURLs)
+	return len(shortURLs), nil
+}
+
+// purge окончательно удаляет записи shortURLs вместе с их переходами
+func (m *memoryStore) purge(shortURLs []string) {
+	for _, shortURL := range shortURLs {
+		m.remove(shortURL)
+	}
+	m.clicksMu.Lock()
+	for _, shortURL := range shortURLs {
+		delete(m.clicks, shortURL)
+	}
+	m.clicksMu.Unlock()
+}
+
+// RestoreUserURLs снимает пометку удаления с URL пользователя и сохраняет изменения в файл
+func (r *repoURL) RestoreUserURLs(ctx context.Context, userID string, urls []string, deletedAfter time.Time) ([]string, error) {
+	log := logger.LoggerFromContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	restored := []string{}
+	events := r.URLMap.restoredCandidates(userID, urls, deletedAfter)
+	if len(events) == 0 {
+		return restored, nil
+	}
+	if err := r.persist(events...); err != nil {
+		log.Errorf("error persist events %s", err)
+		return nil, err
+	}
+	for _, e := range events {
+		r.URLMap.apply(e)
+		restored = append(restored, e.ShortURL)
+	}
+	return restored, nil
+}
+
+// PurgeDeleted окончательно удаляет записи, удалённые раньше deletedBefore.
+// Сначала в журнал дописываются записи об удалении, затем хранилище сжимается
+// и файл аналитики переписывается без переходов по удалённым ссылкам,
+// чтобы ни записи, ни их переходы не оставались на диске.
+func (r *repoURL) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.purgeLocked(deletedBefore)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	if err := r.compactLocked(ctx); err != nil {
+		return n, err
+	}
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+	return n, r.rewriteClicksLocked()
+}
+
+// purgeLocked записывает в журнал и применяет окончательное удаление записей,
+// удалённых раньше deletedBefore. Без записей в журнале его повторное применение
+// после сбоя между заменой снимка и журнала вернуло бы удалённые ссылки.
+// Вызывающий должен держать r.mu.
+func (r *repoURL) purgeLocked(deletedBefore time.Time) (int, error) {
+	shortURLs := r.URLMap.purgeCandidates(deletedBefore)
+	if len(shortURLs) == 0 {
+		return 0, nil
+	}
+	tombstones := make([]models.Event, len(shortURLs))
+	for i, shortURL := range shortURLs {
+		tombstones[i] = models.Event{ShortURL: shortURL, Purged: true}
+	}
+	if err := r.persist(tombstones...); err != nil {
+		return 0, err
+	}
+	r.URLMap.purge(shortURLs)
+	return len(shortURLs), nil
+}
+
+// RestoreUserURLs снимает пометку удаления с URL пользователя, удалённых не раньше deletedAfter
+func (s *Database) RestoreUserURLs(ctx context.Context, userID string, urls []string, deletedAfter time.Time) ([]string, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE shortener SET is_deleted = false, deleted_at = NULL
+		WHERE short_url = ANY($1) AND user_id = $2 AND is_deleted = true AND deleted_at >= $3
+		RETURNING short_url`, urls, userID, deletedAfter)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	restored := []string{}
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			rows.Close()
+			return nil, dbError(err)
+		}
+		restored = append(restored, shortURL)
+	}
+	return restored, dbError(rows.Err())
+}
+
+// PurgeDeleted окончательно удаляет записи, удалённые раньше deletedBefore, вместе с их
+// переходами и историей правок. Если очистку уже выполняет другой экземпляр сервиса,
+// advisory-блокировка не будет получена и метод ничего не сделает.
+func (s *Database) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	log := logger.LoggerFromContext(ctx)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, purgeLockID).Scan(&locked); err != nil {
+		return 0, dbError(err)
+	}
+	if !locked {
+		log.Info("Purge is running on another instance, skipping")
+		return 0, nil
+	}
+
+	tag, err := tx.Exec(ctx,
+		`WITH purged AS (
+			DELETE FROM shortener
+			WHERE is_deleted = true AND (deleted_at IS NULL OR deleted_at < $1)
+			RETURNING short_url
+		), purged_clicks AS (
+			DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM purged)
+		), purged_revisions AS (
+			DELETE FROM url_revisions WHERE short_url IN (SELECT short_url FROM purged)
+		)
+		SELECT short_url FROM purged`, deletedBefore)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	return int(tag.RowsAffected()), dbError(tx.Commit(ctx))
+}
+
+// RunPurger периодически окончательно удаляет записи, удалённые раньше чем grace назад,
+// пока не отменён ctx
+func RunPurger(ctx context.Context, store URLStore, interval, grace time.Duration) {
+	log := logger.LoggerFromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.PurgeDeleted(ctx, time.Now().Add(-grace))
+			if err != nil {
+				log.Errorf("error PurgeDeleted %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Infow("Deleted URLs purged", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/jackc/pgx/v5"
+)
+
+// Интервалы, по которым группируются переходы в статистике
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+)
+
+// bucketDuration возвращает длительность интервала группировки
+func bucketDuration(bucket string) time.Duration {
+	if bucket == BucketHour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// computeStats считает статистику по переходам: всего, уникальных посетителей
+// (по паре усечённый IP + User-Agent) и число переходов в каждом интервале
+func computeStats(shortURL string, clicks []models.Click, bucket string) models.URLStats {
+	stats := models.URLStats{
+		ShortURL:    shortURL,
+		TotalClicks: len(clicks),
+		Bucket:      bucket,
+		Buckets:     []models.StatsBucket{},
+	}
+
+	visitors := make(map[[2]string]bool)
+	counts := make(map[time.Time]int)
+	for _, c := range clicks {
+		visitors[[2]string{c.IP, c.UserAgent}] = true
+		counts[c.Time.UTC().Truncate(bucketDuration(bucket))]++
+	}
+	stats.UniqueVisitors = len(visitors)
+
+	for start, n := range counts {
+		stats.Buckets = append(stats.Buckets, models.StatsBucket{Start: start, Clicks: n})
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool {
+		return stats.Buckets[i].Start.Before(stats.Buckets[j].Start)
+	})
+	return stats
+}
+
+// RecordClicks сохраняет переходы в памяти
+func (m *memoryStore) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	m.clicksMu.Lock()
+	defer m.clicksMu.Unlock()
+	for _, c := range clicks {
+		m.clicks[c.ShortURL] = append(m.clicks[c.ShortURL], c)
+	}
+	return nil
+}
+
+// allClicks возвращает копию всех сохранённых переходов
+func (m *memoryStore) allClicks() []models.Click {
+	m.clicksMu.Lock()
+	defer m.clicksMu.Unlock()
+	var clicks []models.Click
+	for _, c := range m.clicks {
+		clicks = append(clicks, c...)
+	}
+	return clicks
+}
+
+// GetURLStats возвращает статистику переходов по ссылке её владельцу
+func (m *memoryStore) GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error) {
+	event, ok := m.get(shortURL)
+	if !ok {
+		return models.URLStats{}, storageErrors.ErrNotFound
+	}
+	if event.UserID != userID {
+		return models.URLStats{}, storageErrors.ErrForbidden
+	}
+
+	m.clicksMu.Lock()
+	clicks := append([]models.Click(nil), m.clicks[shortURL]...)
+	m.clicksMu.Unlock()
+	return computeStats(shortURL, clicks, bucket), nil
+}
+
+// clicksPath возвращает путь к файлу переходов для журнала filename
+func clicksPath(filename string) string {
+	return filename + ".clicks"
+}
+
+// loadClicks читает переходы из файла аналитики, пропуская повреждённые строки
+// и переходы по ссылкам, которых нет в хранилище. Такие переходы остаются в файле,
+// если сбой случился между очисткой ссылок и перезаписью файла; возвращается их число.
+func (r *repoURL) loadClicks(ctx context.Context, file *os.File) int {
+	log := logger.LoggerFromContext(ctx)
+	var clicks []models.Click
+	skipped, stale := 0, 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var c models.Click
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			skipped++
+			continue
+		}
+		if _, ok := r.URLMap.get(c.ShortURL); !ok {
+			stale++
+			continue
+		}
+		clicks = append(clicks, c)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("error read clicks %s", err)
+	}
+	if skipped > 0 {
+		log.Warnw("Skipped corrupt click records", "file", file.Name(), "count", skipped)
+	}
+	r.URLMap.RecordClicks(ctx, clicks)
+	return stale
+}
+
+// rewriteClicksLocked атомарно заменяет файл аналитики переходами из памяти:
+// переходы окончательно удалённых ссылок в него не попадают, и файл не растёт
+// бесконечно. Вызывающий должен держать clicksMu.
+func (r *repoURL) rewriteClicksLocked() error {
+	clicks := r.URLMap.allClicks()
+	name := clicksPath(r.filename)
+	err := writeFileAtomic(name, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		for _, c := range clicks {
+			data, err := json.Marshal(&c)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	r.clicksFile.Close()
+	r.clicksFile = file
+	return nil
+}
+
+// RecordClicks дописывает переходы в файл аналитики
+func (r *repoURL) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+
+	var buf []byte
+	for _, c := range clicks {
+		data, err := json.Marshal(&c)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	if _, err := r.clicksFile.Write(buf); err != nil {
+		return err
+	}
+	return r.URLMap.RecordClicks(ctx, clicks)
+}
+
+// GetURLStats возвращает статистику переходов по ссылке её владельцу
+func (r *repoURL) GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error) {
+	return r.URLMap.GetURLStats(ctx, userID, shortURL, bucket)
+}
+
+// RecordClicks копирует переходы в таблицу clicks
+func (s *Database) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	_, err := s.db.CopyFrom(ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"short_url", "clicked_at", "referrer", "user_agent", "ip"},
+		pgx.CopyFromSlice(len(clicks), func(i int) ([]any, error) {
+			c := clicks[i]
+			return []any{c.ShortURL, c.Time, c.Referrer, c.UserAgent, c.IP}, nil
+		}))
+	return dbError(err)
+}
+
+// GetURLStats возвращает статистику переходов по ссылке её владельцу
+func (s *Database) GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error) {
+	stats := models.URLStats{ShortURL: shortURL, Bucket: bucket, Buckets: []models.StatsBucket{}}
+
+	var owner string
+	err := s.db.QueryRow(ctx, `SELECT COALESCE(user_id, '') FROM shortener WHERE short_url = $1`, shortURL).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return stats, storageErrors.ErrNotFound
+	}
+	if err != nil {
+		return stats, dbError(err)
+	}
+	if owner != userID {
+		return stats, storageErrors.ErrForbidden
+	}
+
+	err = s.db.QueryRow(ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT (ip, user_agent)) FROM clicks WHERE short_url = $1`,
+		shortURL).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, dbError(err)
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT date_trunc($2, clicked_at AT TIME ZONE 'UTC') AS start, COUNT(*) FROM clicks
+		WHERE short_url = $1 GROUP BY start ORDER BY start`,
+		shortURL, bucket)
+	if err != nil {
+		return stats, dbError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.StatsBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return stats, dbError(err)
+		}
+		stats.Buckets = append(stats.Buckets, b)
+	}
+	return stats, dbError(rows.Err())
+}
+
+// GetServiceStats возвращает число неудалённых сокращённых URL и их владельцев
+func (m *memoryStore) GetServiceStats(ctx context.Context) (models.ServiceStats, error) {
+	var stats models.ServiceStats
+	users := make(map[string]bool)
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for _, e := range s.urls {
+			if e.DeletedFlag {
+				continue
+			}
+			stats.URLs++
+			if e.UserID != "" {
+				users[e.UserID] = true
+			}
+		}
+		s.mu.RUnlock()
+	}
+	stats.Users = len(users)
+	return stats, nil
+}
+
+// GetServiceStats возвращает число неудалённых сокращённых URL и их владельцев
+func (r *repoURL) GetServiceStats(ctx context.Context) (models.ServiceStats, error) {
+	return r.URLMap.GetServiceStats(ctx)
+}
+
+// GetServiceStats возвращает число неудалённых сокращённых URL и их владельцев
+func (s *Database) GetServiceStats(ctx context.Context) (models.ServiceStats, error) {
+	var stats models.ServiceStats
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*), COUNT(DISTINCT NULLIF(user_id, '')) FROM shortener WHERE is_deleted = false`).
+		Scan(&stats.URLs, &stats.Users)
+	return stats, dbError(err)
+}
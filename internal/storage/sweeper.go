@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+)
+
+// RunExpirationSweeper периодически помечает ссылки с истёкшим сроком действия,
+// чтобы они пропали из списков пользователя, пока не отменён ctx
+func RunExpirationSweeper(ctx context.Context, store URLStore, interval time.Duration) {
+	log := logger.LoggerFromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.SweepExpired(ctx)
+			if err != nil {
+				log.Errorf("error SweepExpired %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Infow("Expired URLs swept", "count", n)
+			}
+		}
+	}
+}
@@ -2,43 +2,83 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/11Petrov/urlshortener/cmd/config"
 	"github.com/11Petrov/urlshortener/internal/logger"
 	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
 	"github.com/11Petrov/urlshortener/internal/utils"
 )
 
 // URLStore определяет интерфейс для хранилища URL
 type URLStore interface {
-	ShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	RedirectURL(ctx context.Context, userID, shortURL string) (string, error)
+	ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error)
+	RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error)
 	Ping(ctx context.Context) error
-	BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error)
-	GetUserURLs(ctx context.Context, userID, baseURL string) ([]models.Event, error)
-	DeleteUserURLs(ctx context.Context, userID string, urls []string) error
+	BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error)
+	GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error)
+	DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error)
+	// UpdateURL меняет оригинальный URL ссылки её владельца, сохраняя прежнее значение в истории правок
+	UpdateURL(ctx context.Context, userID, shortURL, originalURL string) (models.Event, error)
+	// RestoreUserURLs снимает пометку удаления с URL пользователя, удалённых не раньше deletedAfter,
+	// и возвращает восстановленные короткие URL
+	RestoreUserURLs(ctx context.Context, userID string, urls []string, deletedAfter time.Time) ([]string, error)
+	// PurgeDeleted окончательно удаляет записи, удалённые раньше deletedBefore, и возвращает их количество
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error)
+	// SweepExpired помечает ссылки с истёкшим сроком действия и возвращает их количество
+	SweepExpired(ctx context.Context) (int, error)
+	// Close освобождает ресурсы хранилища: файлы или пул соединений
+	Close() error
+	// RecordClicks сохраняет переходы по ссылкам
+	RecordClicks(ctx context.Context, clicks []models.Click) error
+	// GetURLStats возвращает статистику переходов по ссылке её владельцу
+	GetURLStats(ctx context.Context, userID, shortURL, bucket string) (models.URLStats, error)
+	// GetServiceStats возвращает число неудалённых сокращённых URL и их владельцев
+	GetServiceStats(ctx context.Context) (models.ServiceStats, error)
 }
 
-// RepoURL - структура, реализующая интерфейс URLStore
+// RepoURL - структура, реализующая интерфейс URLStore.
+// Состояние хранится в memoryStore, а каждая запись дописывается в файл;
+// mu упорядочивает изменения, чтобы порядок строк в файле совпадал с порядком их применения.
 type repoURL struct {
-	URLMap  map[string]string
-	file    *os.File
-	encoder *json.Encoder
+	mu       sync.Mutex
+	URLMap   *memoryStore
+	filename string
+	file     *os.File
+	// logRecords - количество записей в хвосте журнала после последнего снимка
+	logRecords int
+	// closed выставляется в Close, после чего журнал не сжимается
+	closed bool
+
+	clicksMu   sync.Mutex
+	clicksFile *os.File
 }
 
+// NewRepo выбирает хранилище по конфигурации: база данных, если задан DSN,
+// память, если путь к файлу пуст, иначе файловое хранилище
 func NewRepo(cfg *config.Config, ctx context.Context) URLStore {
 	log := logger.LoggerFromContext(ctx)
-	if cfg.DatabaseAddress != "" {
-		store, err := NewDBStore(cfg.DatabaseAddress, ctx)
+	gen, err := utils.NewCodeGenerator(cfg.ShortCodeStrategy, cfg.ShortCodeLength, cfg.ShortCodeAlphabet, cfg.ShortCodeSalt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case cfg.DatabaseAddress != "":
+		store, err := NewDBStore(cfg.DatabaseAddress, gen, ctx)
 		if err != nil {
 			log.Fatal(err)
 		}
 		return store
-	} else {
-		store, err := NewRepoURL(cfg.FilePath, ctx)
+	case cfg.FilePath == "":
+		log.Info("File storage path is empty, using in-memory storage")
+		return NewMemoryStore(gen)
+	default:
+		store, err := NewRepoURL(cfg.FilePath, cfg.FileCompactInterval, gen, ctx)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -46,74 +86,248 @@ func NewRepo(cfg *config.Config, ctx context.Context) URLStore {
 	}
 }
 
-// NewRepoURL создает новый экземпляр RepoURL
-func NewRepoURL(filename string, ctx context.Context) (URLStore, error) {
+// NewRepoURL создает новый экземпляр RepoURL и восстанавливает состояние из снимка и журнала.
+// Оборванная последняя запись журнала отрезается, повреждённые записи переносятся
+// в файл карантина, а итог восстановления пишется в лог.
+// Если compactInterval больше нуля, журнал периодически сжимается в фоне до отмены ctx.
+func NewRepoURL(filename string, compactInterval time.Duration, gen utils.CodeGenerator, ctx context.Context) (URLStore, error) {
 	log := logger.LoggerFromContext(ctx)
+
+	r := &repoURL{
+		URLMap:   newMemoryStore(gen),
+		filename: filename,
+	}
+
+	quarantined := 0
+	snapshot, err := os.Open(snapshotPath(filename))
+	switch {
+	case err == nil:
+		report, err := r.replay(ctx, snapshot)
+		snapshot.Close()
+		if err != nil {
+			log.Errorf("error replay snapshot %s", err)
+			return nil, err
+		}
+		r.logRecovery(ctx, snapshotPath(filename), report)
+		quarantined += report.Quarantined
+	case !os.IsNotExist(err):
+		log.Errorf("error Open snapshot %s", err)
+		return nil, err
+	}
+
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		log.Errorf("error OpneFile %s", err)
 		return nil, err
 	}
+	report, err := r.replay(ctx, file)
+	if err != nil {
+		file.Close()
+		log.Errorf("error replay log %s", err)
+		return nil, err
+	}
+	if report.TornOffset >= 0 {
+		if err := file.Truncate(report.TornOffset); err != nil {
+			file.Close()
+			log.Errorf("error Truncate torn record %s", err)
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.logRecovery(ctx, filename, report)
+	// Счётчик продвигается за наибольшее сохранённое значение, включая окончательно
+	// удалённые ссылки. Журналы без счётчика продвигают его хотя бы на число записей.
+	counter, _ := r.URLMap.counterRecord()
+	utils.SeedGenerator(gen, max(counter.CodeCounter, uint64(r.URLMap.count())))
+	r.logRecords = report.Records
+	r.file = file
+	quarantined += report.Quarantined
 
-	decoder := json.NewDecoder(file)
-	URLMap := make(map[string]string)
-	for {
-		var event models.Event
-		if err := decoder.Decode(&event); err != nil {
-			log.Errorf("error Decode to event %s", err)
-			break
+	// Повреждённые записи уже в карантине: переписываем файлы без них,
+	// чтобы не переносить их в карантин повторно при каждом запуске
+	if quarantined > 0 {
+		if err := r.compact(ctx, true); err != nil {
+			log.Errorf("error compact recovered storage %s", err)
+			return nil, err
 		}
-		URLMap[event.ShortURL] = event.OriginalURL
 	}
 
-	return &repoURL{
-		URLMap:  URLMap,
-		file:    file,
-		encoder: json.NewEncoder(file),
-	}, err
+	clicksFile, err := os.OpenFile(clicksPath(filename), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		log.Errorf("error OpenFile clicks %s", err)
+		return nil, err
+	}
+	stale := r.loadClicks(ctx, clicksFile)
+	r.clicksFile = clicksFile
+	if stale > 0 {
+		log.Warnw("Dropped clicks of purged URLs", "file", clicksFile.Name(), "count", stale)
+		if err := r.rewriteClicksLocked(); err != nil {
+			log.Errorf("error rewrite clicks %s", err)
+			return nil, err
+		}
+	}
+
+	if compactInterval > 0 {
+		go r.runCompaction(ctx, compactInterval)
+	}
+	return r, nil
+}
+
+// replay применяет к хранилищу записи из reader, складывая повреждённые в карантин
+func (r *repoURL) replay(ctx context.Context, reader io.Reader) (recoveryReport, error) {
+	log := logger.LoggerFromContext(ctx)
+	return readRecords(reader, r.URLMap.apply, func(line []byte) {
+		if err := r.quarantine(line); err != nil {
+			log.Errorf("error quarantine record %s", err)
+		}
+	})
+}
+
+// quarantinePath возвращает путь к файлу с повреждёнными записями журнала filename
+func quarantinePath(filename string) string {
+	return filename + ".quarantine"
+}
+
+// quarantine дописывает повреждённую строку в файл карантина
+func (r *repoURL) quarantine(line []byte) error {
+	f, err := os.OpenFile(quarantinePath(r.filename), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// logRecovery пишет в лог итог чтения файла хранилища
+func (r *repoURL) logRecovery(ctx context.Context, filename string, report recoveryReport) {
+	log := logger.LoggerFromContext(ctx)
+	if report.Quarantined == 0 && report.TornOffset < 0 {
+		log.Infow(
+			"File storage loaded",
+			"file", filename,
+			"records", report.Records,
+		)
+		return
+	}
+	log.Warnw(
+		"File storage recovered",
+		"file", filename,
+		"records", report.Records,
+		"legacy records", report.Legacy,
+		"quarantined", report.Quarantined,
+		"quarantine file", quarantinePath(r.filename),
+		"torn tail truncated at", report.TornOffset,
+	)
+}
+
+// persist дописывает записи в конец файла одной операцией записи
+func (r *repoURL) persist(events ...models.Event) error {
+	var buf []byte
+	for _, event := range events {
+		record, err := encodeRecord(event)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, record...)
+	}
+	if _, err := r.file.Write(buf); err != nil {
+		return err
+	}
+	r.logRecords += len(events)
+	return r.file.Sync()
 }
 
 // ShortenURL сокращает оригинальный URL и сохраняет его в хранилище, возвращая сокращенный URL
-func (r *repoURL) ShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+func (r *repoURL) ShortenURL(ctx context.Context, userID, originalURL string, opts models.ShortenOptions) (string, error) {
 	log := logger.LoggerFromContext(ctx)
-	shortURL := utils.GenerateShortURL(originalURL)
-	r.URLMap[shortURL] = originalURL
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	event := models.Event{
-		UserID:      userID,
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
+	if shortURL, ok := r.URLMap.lookupOriginal(originalURL); ok {
+		return shortURL, storageErrors.NewConflict(shortURL)
 	}
-	data, err := json.Marshal(&event)
+	// r.mu не даёт другим записям занять код между подбором и сохранением
+	r.URLMap.indexMu.RLock()
+	shortURL, err := r.URLMap.pickShortURL(originalURL, opts, nil)
+	r.URLMap.indexMu.RUnlock()
 	if err != nil {
-		log.Errorf("error json.Marshal(&event) %s", err)
+		log.Errorf("error pickShortURL %s", err)
 		return "", err
 	}
 
-	_, err = r.file.Write(append(data, '\n'))
-	if err != nil {
-		log.Errorf("error Write %s", err)
+	event := r.URLMap.newEvent(userID, shortURL, originalURL, opts)
+	if err := r.persist(event); err != nil {
+		log.Errorf("error persist event %s", err)
 		return "", err
 	}
-	r.file.Sync()
+	r.URLMap.apply(event)
 	return shortURL, nil
 }
 
-// RedirectURL возвращает оригинальный URL
-func (r *repoURL) RedirectURL(ctx context.Context, userID, shortURL string) (string, error) {
+// RedirectURL возвращает оригинальный URL. Переход по ссылке с лимитом
+// списывается и сохраняется в файл под r.mu, поэтому лимит не превышается
+// и после перезапуска.
+func (r *repoURL) RedirectURL(ctx context.Context, userID, shortURL string, opts models.RedirectOptions) (string, error) {
 	log := logger.LoggerFromContext(ctx)
-	url, ok := r.URLMap[shortURL]
-	if !ok {
-		log.Error("error URLMap[shortURL]")
-		return "", errors.New("url not found")
+	event, ok := r.URLMap.get(shortURL)
+	if !ok || event.ClicksLeft == nil {
+		return r.URLMap.RedirectURL(ctx, userID, shortURL, opts)
 	}
-	return url, nil
+	// Пароль проверяется до блокировки: bcrypt медленный, а хеш пароля у ссылки не меняется
+	if err := checkAccess(event, opts); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event, err := r.URLMap.getActive(shortURL)
+	if err != nil {
+		return "", err
+	}
+	if event.Expired(time.Now()) {
+		return "", storageErrors.ErrExpired
+	}
+	consumed, err := withClickConsumed(event)
+	if err != nil {
+		return "", err
+	}
+	if err := r.persist(consumed); err != nil {
+		log.Errorf("error persist event %s", err)
+		return "", err
+	}
+	r.URLMap.apply(consumed)
+	return consumed.OriginalURL, nil
 }
 
-func (r *repoURL) BatchShortenURL(ctx context.Context, userID, originalURL string) (string, error) {
+// BatchShortenURL сокращает пакет URL целиком: записи о всех ссылках сохраняются
+// в файл одной операцией, а при ошибке не сохраняется ни одна
+func (r *repoURL) BatchShortenURL(ctx context.Context, userID string, items []models.BatchItem) ([]string, error) {
 	log := logger.LoggerFromContext(ctx)
-	log.Info("BatchShortenURL function was called(urls)")
-	return "", nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// r.mu не даёт другим записям занять коды между подбором и сохранением
+	r.URLMap.indexMu.RLock()
+	events, err := r.URLMap.planBatch(userID, items)
+	r.URLMap.indexMu.RUnlock()
+	if err != nil {
+		log.Errorf("error planBatch %s", err)
+		return nil, err
+	}
+
+	if err := r.persist(events...); err != nil {
+		log.Errorf("error persist events %s", err)
+		return nil, err
+	}
+	for _, event := range events {
+		r.URLMap.apply(event)
+	}
+	return shortURLs(events), nil
 }
 
 func (r *repoURL) Ping(ctx context.Context) error {
@@ -122,14 +336,56 @@ func (r *repoURL) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (r *repoURL) GetUserURLs(ctx context.Context, userID, baseURL string) ([]models.Event, error) {
-	log := logger.LoggerFromContext(ctx)
-	log.Info("GetUserURLs was called(urls)")
-	return []models.Event{}, nil
+// GetUserURLs возвращает все URL, сокращённые пользователем
+func (r *repoURL) GetUserURLs(ctx context.Context, userID, baseURL string, opts models.ListOptions) ([]models.Event, error) {
+	return r.URLMap.GetUserURLs(ctx, userID, baseURL, opts)
 }
 
-func (r *repoURL) DeleteUserURLs(ctx context.Context, userID string, urls []string) error {
+// DeleteUserURLs помечает URL пользователя как удалённые, сохраняет пометку в файл
+// и возвращает число помеченных ссылок
+func (r *repoURL) DeleteUserURLs(ctx context.Context, userID string, urls []string) (int, error) {
 	log := logger.LoggerFromContext(ctx)
-	log.Info("DeleteUserURLs was called(urls)")
-	return nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	var events []models.Event
+	for _, shortURL := range urls {
+		event, ok := r.URLMap.get(shortURL)
+		if !ok || event.UserID != userID || event.DeletedFlag {
+			continue
+		}
+		event.DeletedFlag = true
+		event.DeletedAt = &now
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := r.persist(events...); err != nil {
+		log.Errorf("error persist events %s", err)
+		return 0, err
+	}
+	for _, event := range events {
+		r.URLMap.apply(event)
+	}
+	return len(events), nil
+}
+
+// SweepExpired помечает просроченные ссылки и сохраняет пометки в файл
+func (r *repoURL) SweepExpired(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := r.URLMap.expiredCandidates(time.Now())
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := r.persist(events...); err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		r.URLMap.apply(e)
+	}
+	return len(events), nil
 }
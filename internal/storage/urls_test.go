@@ -0,0 +1,487 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/models"
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/11Petrov/urlshortener/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoURLCompact(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+
+	kept, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	deleted, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	_, err = store.ShortenURL(ctx, "user2", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.Error(t, err)
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{deleted})
+	require.NoError(t, err)
+
+	require.NoError(t, store.(*repoURL).Compact(ctx))
+
+	logData, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Empty(t, logData)
+	snapshotData, err := os.ReadFile(snapshotPath(filename))
+	require.NoError(t, err)
+	// удалённая запись остаётся в снимке до окончательной очистки
+	assert.Equal(t, 2, strings.Count(string(snapshotData), "\n"))
+
+	// записи после сжатия попадают в новый журнал
+	added, err := store.ShortenURL(ctx, "user2", "https://go.dev/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	for _, shortURL := range []string{kept, added} {
+		_, err := reopened.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+		assert.NoError(t, err)
+	}
+	_, err = reopened.RedirectURL(ctx, "", deleted, models.RedirectOptions{})
+	assert.Error(t, err)
+}
+
+func TestRepoURLRecovery(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	first, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	second, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	third, err := store.ShortenURL(ctx, "user1", "https://go.dev/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.Len(t, lines, 4)
+	// портим вторую запись и обрываем третью на середине
+	corrupted := strings.Replace(lines[1], "yandex", "yandeX", 1)
+	torn := lines[2][:len(lines[2])/2]
+	require.NoError(t, os.WriteFile(filename, []byte(lines[0]+corrupted+torn), 0666))
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+
+	_, err = reopened.RedirectURL(ctx, "", first, models.RedirectOptions{})
+	assert.NoError(t, err)
+	for _, shortURL := range []string{second, third} {
+		_, err = reopened.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+		assert.Error(t, err)
+	}
+
+	quarantined, err := os.ReadFile(quarantinePath(filename))
+	require.NoError(t, err)
+	assert.Equal(t, corrupted, string(quarantined))
+
+	// повреждённая запись вынесена в карантин, а журнал пересобран в снимок
+	data, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	// новая запись дописывается после восстановленного журнала
+	_, err = reopened.ShortenURL(ctx, "user1", "https://go.dev/", models.ShortenOptions{})
+	require.NoError(t, err)
+	again, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	_, err = again.RedirectURL(ctx, "", third, models.RedirectOptions{})
+	assert.NoError(t, err)
+
+	quarantined, err = os.ReadFile(quarantinePath(filename))
+	require.NoError(t, err)
+	assert.Equal(t, corrupted, string(quarantined))
+}
+
+func TestClickLimitConcurrent(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+
+	fileStore, err := NewRepoURL(filepath.Join(t.TempDir(), "short-url-db.json"), 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	stores := map[string]URLStore{
+		"memory": NewMemoryStore(utils.HashGenerator{}),
+		"file":   fileStore,
+	}
+
+	const maxClicks = 5
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			shortURL, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/",
+				models.ShortenOptions{MaxClicks: maxClicks})
+			require.NoError(t, err)
+
+			var served atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := store.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+					if err == nil {
+						served.Add(1)
+					} else if !errors.Is(err, storageErrors.ErrClicksExhausted) {
+						t.Errorf("RedirectURL: %s", err)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int32(maxClicks), served.Load())
+		})
+	}
+
+	reopened, err := NewRepoURL(fileStore.(*repoURL).filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	_, err = reopened.RedirectURL(ctx, "", utils.GenerateShortURL("https://practicum.yandex.ru/"), models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrClicksExhausted)
+}
+
+func TestRepoURLUpdate(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+
+	shortURL, err := store.ShortenURL(ctx, "user1", "https://practicum.yandx.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	_, err = store.ShortenURL(ctx, "user1", "https://go.dev/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	_, err = store.UpdateURL(ctx, "user2", shortURL, "https://practicum.yandex.ru/")
+	assert.ErrorIs(t, err, storageErrors.ErrForbidden)
+	_, err = store.UpdateURL(ctx, "user1", "missing", "https://practicum.yandex.ru/")
+	assert.ErrorIs(t, err, storageErrors.ErrNotFound)
+	_, err = store.UpdateURL(ctx, "user1", shortURL, "https://go.dev/")
+	assert.ErrorIs(t, err, storageErrors.ErrUnique)
+
+	event, err := store.UpdateURL(ctx, "user1", shortURL, "https://practicum.yandex.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", event.OriginalURL)
+	require.Len(t, event.Revisions, 1)
+	assert.Equal(t, "https://practicum.yandx.ru/", event.Revisions[0].OriginalURL)
+
+	// старый адрес освобождается, новый закреплён за ссылкой
+	_, err = store.ShortenURL(ctx, "user2", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrUnique)
+	_, err = store.ShortenURL(ctx, "user2", "https://practicum.yandx.ru/", models.ShortenOptions{})
+	assert.NoError(t, err)
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	url, err := reopened.RedirectURL(ctx, "", shortURL, models.RedirectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", url)
+	events, err := reopened.GetUserURLs(ctx, "user1", "", models.ListOptions{})
+	require.NoError(t, err)
+	for _, e := range events {
+		if e.OriginalURL == url {
+			assert.Len(t, e.Revisions, 1)
+		}
+	}
+}
+
+func TestRepoURLRestorePurge(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+
+	restored, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	purged, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{restored, purged})
+	require.NoError(t, err)
+
+	// восстановить можно только свою ссылку и только в пределах срока
+	got, err := store.RestoreUserURLs(ctx, "user2", []string{restored}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	got, err = store.RestoreUserURLs(ctx, "user1", []string{restored}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	got, err = store.RestoreUserURLs(ctx, "user1", []string{restored}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{restored}, got)
+
+	n, err := store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	_, err = reopened.RedirectURL(ctx, "", restored, models.RedirectOptions{})
+	assert.NoError(t, err)
+	got, err = reopened.RestoreUserURLs(ctx, "user1", []string{purged}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	_, err = reopened.ShortenURL(ctx, "user2", "https://yandex.ru/", models.ShortenOptions{})
+	assert.NoError(t, err)
+}
+
+func TestRepoURLPurgeCrashBeforeLogReset(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	r := store.(*repoURL)
+
+	kept, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	purged, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{purged})
+	require.NoError(t, err)
+
+	// процесс падает после записи снимка, но до замены журнала:
+	// в журнале остаются и создание, и удаление очищенной ссылки
+	r.mu.Lock()
+	n, err := r.purgeLocked(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, r.writeSnapshot(r.URLMap.snapshot()))
+	r.mu.Unlock()
+	require.NoError(t, store.Close())
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	_, err = reopened.RedirectURL(ctx, "", kept, models.RedirectOptions{})
+	assert.NoError(t, err)
+	_, err = reopened.RedirectURL(ctx, "", purged, models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrNotFound, "old log must not bring the purged record back")
+	_, err = reopened.ShortenURL(ctx, "user2", "https://yandex.ru/", models.ShortenOptions{})
+	assert.NoError(t, err)
+}
+
+func TestRepoURLReopen(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+
+	var user1 []string
+	for _, url := range []string{"https://practicum.yandex.ru/", "https://yandex.ru/", "https://go.dev/"} {
+		shortURL, err := store.ShortenURL(ctx, "user1", url, models.ShortenOptions{})
+		require.NoError(t, err)
+		user1 = append(user1, shortURL)
+	}
+	user2, err := store.ShortenURL(ctx, "user2", "https://example.com/", models.ShortenOptions{})
+	require.NoError(t, err)
+
+	// чужие ссылки не удаляются, свои - удаляются
+	_, err = store.DeleteUserURLs(ctx, "user2", []string{user1[0]})
+	require.NoError(t, err)
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{user1[1], user2})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	// после повторного чтения журнала ссылки идут в порядке создания, а пометки удаления сохранены
+	events, err := reopened.GetUserURLs(ctx, "user1", "http://localhost:8080", models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	for i, e := range events {
+		assert.Equal(t, "http://localhost:8080/"+user1[i], e.ShortURL)
+		assert.Equal(t, i == 1, e.DeletedFlag, e.ShortURL)
+	}
+	events, err = reopened.GetUserURLs(ctx, "user2", "http://localhost:8080", models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.False(t, events[0].DeletedFlag)
+
+	_, err = reopened.RedirectURL(ctx, "", user1[0], models.RedirectOptions{})
+	assert.NoError(t, err)
+	_, err = reopened.RedirectURL(ctx, "", user1[1], models.RedirectOptions{})
+	assert.ErrorIs(t, err, storageErrors.ErrDeleted)
+	_, err = reopened.RedirectURL(ctx, "", user2, models.RedirectOptions{})
+	assert.NoError(t, err)
+
+	events, err = reopened.GetUserURLs(ctx, "user3", "http://localhost:8080", models.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestBatchShortenURLAtomic(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+
+	stores := map[string]func(t *testing.T) URLStore{
+		"memory": func(t *testing.T) URLStore { return NewMemoryStore(utils.HashGenerator{}) },
+		"file": func(t *testing.T) URLStore {
+			store, err := NewRepoURL(filepath.Join(t.TempDir(), "short-url-db.json"), 0, utils.HashGenerator{}, ctx)
+			require.NoError(t, err)
+			return store
+		},
+	}
+	fresh := models.BatchItem{OriginalURL: "https://go.dev/"}
+	tests := []struct {
+		name  string
+		items []models.BatchItem
+		index int
+		err   error
+	}{
+		{
+			name:  "already shortened",
+			items: []models.BatchItem{fresh, {OriginalURL: "https://practicum.yandex.ru/"}},
+			index: 1,
+			err:   storageErrors.ErrUnique,
+		},
+		{
+			name:  "repeated in batch",
+			items: []models.BatchItem{fresh, {OriginalURL: "https://example.com/"}, fresh},
+			index: 2,
+			err:   storageErrors.ErrBatchDuplicate,
+		},
+		{
+			name:  "alias taken",
+			items: []models.BatchItem{fresh, {OriginalURL: "https://example.com/", Options: models.ShortenOptions{Alias: "taken"}}},
+			index: 1,
+			err:   storageErrors.ErrAliasTaken,
+		},
+	}
+	for name, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				store := newStore(t)
+				existing, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{Alias: "taken"})
+				require.NoError(t, err)
+
+				_, err = store.BatchShortenURL(ctx, "user1", tt.items)
+				require.ErrorIs(t, err, tt.err)
+				index, ok := storageErrors.BatchIndex(err)
+				require.True(t, ok)
+				assert.Equal(t, tt.index, index)
+				if errors.Is(err, storageErrors.ErrUnique) {
+					shortURL, _ := storageErrors.ExistingShortURL(err)
+					assert.Equal(t, existing, shortURL)
+				}
+
+				// ни одна ссылка пакета не сохранена, и тот же URL можно сократить снова
+				events, err := store.GetUserURLs(ctx, "user1", "", models.ListOptions{})
+				require.NoError(t, err)
+				assert.Len(t, events, 1)
+				shortURLs, err := store.BatchShortenURL(ctx, "user1", []models.BatchItem{fresh})
+				require.NoError(t, err)
+				assert.Len(t, shortURLs, 1)
+			})
+		}
+	}
+}
+
+func TestRepoURLPurgedCodesNotReissued(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+	newGen := func() utils.CodeGenerator {
+		gen, err := utils.NewCodeGenerator(utils.StrategySequence, 0, "", "")
+		require.NoError(t, err)
+		return gen
+	}
+
+	store, err := NewRepoURL(filename, 0, newGen(), ctx)
+	require.NoError(t, err)
+	first, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	second, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2"}, []string{first, second})
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{second})
+	require.NoError(t, err)
+	// очистка сжимает журнал, и в снимке остаётся только первая ссылка
+	n, err := store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, store.Close())
+
+	reopened, err := NewRepoURL(filename, 0, newGen(), ctx)
+	require.NoError(t, err)
+	defer reopened.Close()
+	next, err := reopened.ShortenURL(ctx, "user2", "https://go.dev/", models.ShortenOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "3", next, "code of a purged link must not be issued again")
+}
+
+func TestRepoURLPurgeDropsClicks(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	store, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	kept, err := store.ShortenURL(ctx, "user1", "https://practicum.yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	purged, err := store.ShortenURL(ctx, "user1", "https://yandex.ru/", models.ShortenOptions{})
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, store.RecordClicks(ctx, []models.Click{
+		{ShortURL: kept, Time: now, IP: "10.0.0.0"},
+		{ShortURL: purged, Time: now, IP: "10.0.0.0"},
+		{ShortURL: purged, Time: now, IP: "10.0.1.0"},
+	}))
+	_, err = store.DeleteUserURLs(ctx, "user1", []string{purged})
+	require.NoError(t, err)
+	n, err := store.PurgeDeleted(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	data, err := os.ReadFile(clicksPath(filename))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"`+purged+`"`, "clicks of a purged link must leave the clicks file")
+	require.NoError(t, store.RecordClicks(ctx, []models.Click{{ShortURL: kept, Time: now, IP: "10.0.2.0"}}))
+	require.NoError(t, store.Close())
+
+	// переход по очищенной ссылке, оставшийся в файле после сбоя, не загружается
+	f, err := os.OpenFile(clicksPath(filename), os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"short_url":"` + purged + `","time":"` + now.Format(time.RFC3339) + `"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := NewRepoURL(filename, 0, utils.HashGenerator{}, ctx)
+	require.NoError(t, err)
+	defer reopened.Close()
+	stats, err := reopened.GetURLStats(ctx, "user1", kept, BucketDay)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.TotalClicks)
+
+	// код очищенной ссылки можно занять снова, и чужие переходы к нему не прилипают
+	reused, err := reopened.ShortenURL(ctx, "user2", "https://go.dev/", models.ShortenOptions{Alias: purged})
+	require.NoError(t, err)
+	require.Equal(t, purged, reused)
+	stats, err = reopened.GetURLStats(ctx, "user2", reused, BucketDay)
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalClicks)
+}
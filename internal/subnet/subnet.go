@@ -0,0 +1,102 @@
+package subnet
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/11Petrov/urlshortener/internal/problem"
+)
+
+// RealIPHeader - заголовок, из которого берётся IP-адрес клиента
+const RealIPHeader = "X-Real-IP"
+
+// Trusted пропускает только запросы из доверенной подсети.
+// Подсеть можно заменить на лету через Set.
+type Trusted struct {
+	network atomic.Pointer[net.IPNet]
+}
+
+// NewTrusted разбирает подсеть в нотации CIDR.
+// Пустая строка означает, что доверенной подсети нет и все запросы отклоняются.
+func NewTrusted(cidr string) (*Trusted, error) {
+	t := &Trusted{}
+	if err := t.Set(cidr); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Set заменяет доверенную подсеть; пустая строка запрещает все запросы
+func (t *Trusted) Set(cidr string) error {
+	if cidr == "" {
+		t.network.Store(nil)
+		return nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	t.network.Store(network)
+	return nil
+}
+
+// Contains сообщает, входит ли адрес из заголовка X-Real-IP в доверенную подсеть
+func (t *Trusted) Contains(r *http.Request) bool {
+	return t.ContainsIP(r.Header.Get(RealIPHeader))
+}
+
+// ContainsIP сообщает, входит ли адрес в доверенную подсеть
+func (t *Trusted) ContainsIP(addr string) bool {
+	network := t.network.Load()
+	if network == nil {
+		return false
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	return ip != nil && network.Contains(ip)
+}
+
+// PeerIP возвращает IP-адрес соединения r.RemoteAddr без порта
+func PeerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ContainsPeer сообщает, входит ли в доверенную подсеть адрес соединения.
+// В отличие от Contains заголовки не учитываются, поэтому клиент не может подделать адрес.
+func (t *Trusted) ContainsPeer(r *http.Request) bool {
+	return t.ContainsIP(PeerIP(r))
+}
+
+// Middleware отвечает 403 в формате problem+json, если запрос пришёл не из доверенной подсети
+func (t *Trusted) Middleware(h http.HandlerFunc) http.HandlerFunc {
+	return t.guard(h, t.Contains)
+}
+
+// PeerMiddleware - Middleware, который проверяет адрес соединения вместо X-Real-IP.
+// Нужен для служебных обработчиков, которые нельзя открыть подделкой заголовка.
+func (t *Trusted) PeerMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return t.guard(h, t.ContainsPeer)
+}
+
+// guard пропускает к h только запросы, для которых allowed возвращает true
+func (t *Trusted) guard(h http.HandlerFunc, allowed func(r *http.Request) bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !allowed(r) {
+			log := logger.LoggerFromContext(r.Context())
+			log.Infow("Rejected request from untrusted address",
+				"path", r.URL.Path,
+				"ip", r.Header.Get(RealIPHeader),
+				"peer", PeerIP(r),
+			)
+			problem.Write(rw, r, http.StatusForbidden, problem.CodeForbidden, "request is not from the trusted subnet")
+			return
+		}
+		h(rw, r)
+	}
+}
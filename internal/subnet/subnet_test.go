@@ -0,0 +1,101 @@
+package subnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/11Petrov/urlshortener/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedMiddleware(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+
+	tests := []struct {
+		name   string
+		cidr   string
+		ip     string
+		status int
+	}{
+		{name: "inside subnet", cidr: "192.168.1.0/24", ip: "192.168.1.15", status: http.StatusOK},
+		{name: "outside subnet", cidr: "192.168.1.0/24", ip: "10.0.0.1", status: http.StatusForbidden},
+		{name: "no header", cidr: "192.168.1.0/24", ip: "", status: http.StatusForbidden},
+		{name: "subnet unset", cidr: "", ip: "192.168.1.15", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := NewTrusted(tt.cidr)
+			require.NoError(t, err)
+
+			h := trusted.Middleware(func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil).WithContext(ctx)
+			if tt.ip != "" {
+				req.Header.Set(RealIPHeader, tt.ip)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+
+	_, err := NewTrusted("not-a-cidr")
+	assert.Error(t, err)
+}
+
+func TestTrustedSet(t *testing.T) {
+	trusted, err := NewTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	req.Header.Set(RealIPHeader, "192.168.1.15")
+	assert.False(t, trusted.Contains(req))
+
+	require.NoError(t, trusted.Set("192.168.1.0/24"))
+	assert.True(t, trusted.Contains(req))
+
+	// неверная подсеть не заменяет действующую
+	assert.Error(t, trusted.Set("192.168.1.0"))
+	assert.True(t, trusted.Contains(req))
+
+	require.NoError(t, trusted.Set(""))
+	assert.False(t, trusted.Contains(req))
+}
+
+func TestTrustedPeerMiddleware(t *testing.T) {
+	testlog := logger.NewLogger()
+	ctx := logger.ContextWithLogger(context.Background(), &testlog)
+	trusted, err := NewTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		peer   string
+		realIP string
+		status int
+	}{
+		{name: "trusted peer", peer: "10.1.2.3:5000", status: http.StatusOK},
+		{name: "untrusted peer", peer: "203.0.113.7:5000", status: http.StatusForbidden},
+		// заголовок задаёт сам клиент, поэтому он не открывает доступ
+		{name: "forged header", peer: "203.0.113.7:5000", realIP: "10.1.2.3", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := trusted.PeerMiddleware(func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil).WithContext(ctx)
+			req.RemoteAddr = tt.peer
+			if tt.realIP != "" {
+				req.Header.Set(RealIPHeader, tt.realIP)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity - срок действия самоподписанного сертификата
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// versions - поддерживаемые минимальные версии TLS
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewConfig собирает настройки TLS сервера.
+// minVersion - минимальная версия протокола: 1.2 или 1.3.
+// cipherSuites - имена наборов шифров через запятую, например TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256;
+// пустая строка оставляет наборы Go по умолчанию. Наборы задаются только для TLS 1.2,
+// в TLS 1.3 они не настраиваются. Допускаются только наборы, которые Go считает безопасными.
+func NewConfig(minVersion, cipherSuites string) (*tls.Config, error) {
+	version, ok := versions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS version %q: use 1.2 or 1.3", minVersion)
+	}
+	cfg := &tls.Config{MinVersion: version}
+
+	if cipherSuites == "" {
+		return cfg, nil
+	}
+	secure := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		secure[cs.Name] = cs.ID
+	}
+	for _, name := range strings.Split(cipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	return cfg, nil
+}
+
+// SelfSigned создает самоподписанный сертификат для разработки.
+// hosts - DNS-имена и IP-адреса, для которых действителен сертификат.
+func SelfSigned(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"urlshortener development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,51 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		minVersion string
+		ciphers    string
+		wantErr    bool
+		wantSuites []uint16
+	}{
+		{name: "defaults", minVersion: "1.2"},
+		{name: "tls13", minVersion: "1.3"},
+		{name: "old version", minVersion: "1.0", wantErr: true},
+		{
+			name:       "ciphers",
+			minVersion: "1.2",
+			ciphers:    "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			wantSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		{name: "insecure cipher", minVersion: "1.2", ciphers: "TLS_RSA_WITH_RC4_128_SHA", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewConfig(tt.minVersion, tt.ciphers)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, versions[tt.minVersion], cfg.MinVersion)
+			assert.Equal(t, tt.wantSuites, cfg.CipherSuites)
+		})
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned([]string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	assert.NoError(t, cert.Leaf.VerifyHostname("localhost"))
+	assert.True(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+}
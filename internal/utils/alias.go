@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxAliasLength - максимальная длина пользовательского короткого кода
+const MaxAliasLength = 64
+
+var aliasPattern = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// reservedAliases - коды, совпадающие с путями сервиса или зарезервированные под них
+var reservedAliases = map[string]bool{
+	"api":      true,
+	"ping":     true,
+	"admin":    true,
+	"debug":    true,
+	"health":   true,
+	"internal": true,
+	"metrics":  true,
+	"static":   true,
+}
+
+// ValidateAlias проверяет пользовательский короткий код: допустимые символы,
+// длину и отсутствие в списке зарезервированных слов
+func ValidateAlias(alias string) error {
+	if len(alias) > MaxAliasLength {
+		return fmt.Errorf("alias must be at most %d characters long", MaxAliasLength)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("alias %q may contain only latin letters, digits, '-' and '_'", alias)
+	}
+	if IsReserved(alias) {
+		return fmt.Errorf("alias %q is reserved", alias)
+	}
+	return nil
+}
+
+// IsReserved сообщает, совпадает ли короткий код с зарезервированным словом.
+// Такие коды перекрыты путями сервиса, поэтому генераторы их пропускают, как занятые.
+func IsReserved(code string) bool {
+	return reservedAliases[strings.ToLower(code)]
+}
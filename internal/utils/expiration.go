@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExpiresAt возвращает момент истечения ссылки по абсолютному времени
+// или длительности из запроса; нулевое время означает бессрочную ссылку
+func ExpiresAt(expiresAt time.Time, ttl string, now time.Time) (time.Time, error) {
+	if ttl != "" {
+		if !expiresAt.IsZero() {
+			return time.Time{}, errors.New("only one of expires_at and ttl may be set")
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid ttl %q: %s", ttl, err)
+		}
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("ttl must be positive, got %s", ttl)
+		}
+		return now.Add(d), nil
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return time.Time{}, errors.New("expires_at must be in the future")
+	}
+	return expiresAt, nil
+}
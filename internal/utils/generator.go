@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+)
+
+// Стратегии формирования коротких кодов
+const (
+	StrategyHash     = "hash"
+	StrategySequence = "sequence"
+	StrategyRandom   = "random"
+	StrategyHashids  = "hashids"
+)
+
+// Base62Alphabet - алфавит по умолчанию для последовательных и случайных кодов
+const Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// CodeGenerator формирует короткие коды для URL
+type CodeGenerator interface {
+	// Generate возвращает код для попытки attempt. Если код уже занят,
+	// хранилище вызывает Generate снова со следующим номером попытки.
+	Generate(originalURL string, attempt int) (string, error)
+}
+
+// Seeder реализуют генераторы со счётчиком, которые нужно продвинуть
+// за уже выданные коды при открытии хранилища. Хранилище сохраняет значение
+// Counter вместе со ссылками, чтобы после перезапуска не выдать код повторно.
+type Seeder interface {
+	Seed(n uint64)
+	// Counter возвращает текущее значение счётчика - не меньше последнего выданного
+	Counter() uint64
+}
+
+// SeedGenerator продвигает счётчик генератора, если он у него есть
+func SeedGenerator(gen CodeGenerator, n uint64) {
+	if s, ok := gen.(Seeder); ok {
+		s.Seed(n)
+	}
+}
+
+// GeneratorCounter возвращает значение счётчика генератора или 0, если счётчика у него нет
+func GeneratorCounter(gen CodeGenerator) uint64 {
+	if s, ok := gen.(Seeder); ok {
+		return s.Counter()
+	}
+	return 0
+}
+
+// NewCodeGenerator создает генератор по названию стратегии.
+// length задаёт длину случайных кодов и минимальную длину hashids,
+// alphabet и salt используются стратегиями, которым они нужны.
+func NewCodeGenerator(strategy string, length int, alphabet, salt string) (CodeGenerator, error) {
+	if alphabet == "" {
+		alphabet = Base62Alphabet
+	}
+	if strategy != StrategyHash {
+		if err := validateAlphabet(alphabet); err != nil {
+			return nil, err
+		}
+	}
+
+	switch strategy {
+	case StrategyHash, "":
+		return HashGenerator{}, nil
+	case StrategySequence:
+		return &SequenceGenerator{alphabet: alphabet}, nil
+	case StrategyRandom:
+		if length <= 0 {
+			return nil, fmt.Errorf("random code length must be positive, got %d", length)
+		}
+		return &RandomGenerator{length: length, alphabet: alphabet}, nil
+	case StrategyHashids:
+		if salt == "" {
+			return nil, errors.New("hashids strategy requires a salt")
+		}
+		return &HashidsGenerator{alphabet: alphabet, salt: salt, minLength: length}, nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy %q", strategy)
+	}
+}
+
+// validateAlphabet проверяет, что алфавит состоит хотя бы из двух различных символов,
+// допустимых в пути URL без экранирования
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("alphabet must contain at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		if !strings.ContainsRune(Base62Alphabet+"-_", c) {
+			return fmt.Errorf("alphabet character %q is not URL-safe", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("alphabet character %q is repeated", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// HashGenerator формирует детерминированный код из хеша URL
+type HashGenerator struct{}
+
+func (HashGenerator) Generate(originalURL string, attempt int) (string, error) {
+	return GenerateShortURLAttempt(originalURL, attempt), nil
+}
+
+// SequenceGenerator выдаёт коды из возрастающего счётчика в системе счисления алфавита
+type SequenceGenerator struct {
+	alphabet string
+	counter  atomic.Uint64
+}
+
+// Seed продвигает счётчик не меньше чем до n
+func (g *SequenceGenerator) Seed(n uint64) {
+	for {
+		current := g.counter.Load()
+		if current >= n || g.counter.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+// Counter возвращает текущее значение счётчика
+func (g *SequenceGenerator) Counter() uint64 {
+	return g.counter.Load()
+}
+
+// next возвращает следующее значение счётчика. При коллизии счётчик
+// перескакивает вперёд на 2^attempt, чтобы быстро пройти занятые после
+// перезапуска значения.
+func (g *SequenceGenerator) next(attempt int) uint64 {
+	step := uint64(1)
+	if attempt > 0 {
+		step = 1 << min(attempt, 32)
+	}
+	return g.counter.Add(step)
+}
+
+func (g *SequenceGenerator) Generate(originalURL string, attempt int) (string, error) {
+	return encodeNumber(g.next(attempt), g.alphabet), nil
+}
+
+// RandomGenerator формирует криптографически случайные коды заданной длины
+type RandomGenerator struct {
+	length   int
+	alphabet string
+}
+
+func (g *RandomGenerator) Generate(originalURL string, attempt int) (string, error) {
+	max := big.NewInt(int64(len(g.alphabet)))
+	code := make([]byte, g.length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// HashidsGenerator выдаёт коды из счётчика, как SequenceGenerator, но кодирует
+// их алфавитом, перемешанным с солью, по схеме Hashids: соседние значения
+// дают непохожие коды, а без соли порядок выдачи по коду не восстановить.
+type HashidsGenerator struct {
+	SequenceGenerator
+	alphabet  string
+	salt      string
+	minLength int
+}
+
+func (g *HashidsGenerator) Generate(originalURL string, attempt int) (string, error) {
+	n := g.next(attempt)
+	alphabet := []byte(g.alphabet)
+	// «лотерейный» символ зависит от числа и определяет перемешивание для остальных
+	lottery := alphabet[n%uint64(len(alphabet))]
+	consistentShuffle(alphabet, append([]byte{lottery}, g.salt...))
+
+	code := []byte{lottery}
+	code = append(code, encodeNumber(n, string(alphabet))...)
+	for i := 0; len(code) < g.minLength; i++ {
+		consistentShuffle(alphabet, []byte(g.salt))
+		code = append(code, alphabet[(int(n)+i)%len(alphabet)])
+	}
+	return string(code), nil
+}
+
+// consistentShuffle детерминированно перемешивает alphabet по salt (алгоритм Hashids)
+func consistentShuffle(alphabet, salt []byte) {
+	if len(salt) == 0 {
+		return
+	}
+	for i, v, p := len(alphabet)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+		v++
+	}
+}
+
+// encodeNumber записывает n в системе счисления с основанием len(alphabet)
+func encodeNumber(n uint64, alphabet string) string {
+	base := uint64(len(alphabet))
+	var buf []byte
+	for {
+		buf = append(buf, alphabet[n%base])
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return string(buf)
+}
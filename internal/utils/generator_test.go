@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		length    int
+		alphabet  string
+		salt      string
+		minLength int
+	}{
+		{name: "hash", strategy: StrategyHash, minLength: 8},
+		{name: "sequence", strategy: StrategySequence, minLength: 1},
+		{name: "random", strategy: StrategyRandom, length: 12, alphabet: "abcdef", minLength: 12},
+		{name: "hashids", strategy: StrategyHashids, length: 5, salt: "pepper", minLength: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen, err := NewCodeGenerator(tt.strategy, tt.length, tt.alphabet, tt.salt)
+			require.NoError(t, err)
+
+			alphabet := tt.alphabet
+			if alphabet == "" {
+				alphabet = Base62Alphabet
+			}
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				code, err := gen.Generate("https://practicum.yandex.ru/"+strings.Repeat("x", i), 0)
+				require.NoError(t, err)
+				assert.GreaterOrEqual(t, len(code), tt.minLength)
+				for _, c := range code {
+					assert.Contains(t, alphabet, string(c))
+				}
+				assert.False(t, seen[code], "duplicate code %s", code)
+				seen[code] = true
+			}
+		})
+	}
+}
+
+func TestSequenceGeneratorSeed(t *testing.T) {
+	gen, err := NewCodeGenerator(StrategySequence, 0, "0123456789", "")
+	require.NoError(t, err)
+	SeedGenerator(gen, 41)
+
+	code, err := gen.Generate("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "42", code)
+}
+
+func TestNewCodeGeneratorErrors(t *testing.T) {
+	_, err := NewCodeGenerator("unknown", 8, "", "")
+	assert.Error(t, err)
+	_, err = NewCodeGenerator(StrategyRandom, 0, "", "")
+	assert.Error(t, err)
+	_, err = NewCodeGenerator(StrategyHashids, 8, "", "")
+	assert.Error(t, err)
+	_, err = NewCodeGenerator(StrategySequence, 8, "a/b", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts - порты схем, которые не записываются в каноническом URL
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLNormalizer проверяет сокращаемые URL и приводит их к каноническому виду,
+// чтобы один и тот же адрес, записанный по-разному, не получал разные короткие ссылки
+type URLNormalizer struct {
+	// SortQuery включает сортировку параметров запроса по имени
+	SortQuery bool
+}
+
+// Normalize возвращает канонический вид URL raw: схема и хост в нижнем регистре,
+// IDN-хост в punycode, без порта по умолчанию и с путём не короче "/".
+// Допускаются только абсолютные http- и https-URL без учётных данных.
+func (n URLNormalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("url must not be empty")
+	}
+	if i := strings.IndexFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }); i >= 0 {
+		return "", fmt.Errorf("url %q must not contain whitespace or control characters", raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("url %q is malformed", raw)
+	}
+	if u.Scheme == "" {
+		return "", fmt.Errorf("url %q must be absolute and start with http:// or https://", raw)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", fmt.Errorf("url %q: scheme must be http or https", raw)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", fmt.Errorf("url %q must contain a host", raw)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("url %q must not contain credentials", raw)
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("url %q: %w", raw, err)
+	}
+	port := u.Port()
+	if port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", fmt.Errorf("url %q: invalid port %q", raw, port)
+		}
+	}
+	if port == "" || port == defaultPorts[u.Scheme] {
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	} else {
+		u.Host = net.JoinHostPort(host, port)
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+	if n.SortQuery {
+		u.RawQuery = sortQuery(u.RawQuery)
+	}
+	return u.String(), nil
+}
+
+// normalizeHost приводит имя хоста к нижнему регистру и переводит IDN в punycode;
+// IP-адреса возвращаются в каноническом текстовом виде
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("host must not be empty")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return ascii, nil
+}
+
+// sortQuery упорядочивает параметры запроса по имени, сохраняя исходное кодирование
+// и порядок значений одного параметра; пустые параметры отбрасываются
+func sortQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.FieldsFunc(rawQuery, func(r rune) bool { return r == '&' })
+	key := func(p string) string {
+		k, _, _ := strings.Cut(p, "=")
+		if unescaped, err := url.QueryUnescape(k); err == nil {
+			return unescaped
+		}
+		return k
+	}
+	sort.SliceStable(params, func(i, j int) bool { return key(params[i]) < key(params[j]) })
+	return strings.Join(params, "&")
+}
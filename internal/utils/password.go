@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordLength - максимальная длина пароля ссылки, которую принимает bcrypt
+const MaxPasswordLength = 72
+
+// HashPassword возвращает bcrypt-хеш пароля ссылки
+func HashPassword(password string) (string, error) {
+	if len(password) > MaxPasswordLength {
+		return "", fmt.Errorf("password must be at most %d bytes long", MaxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword сообщает, соответствует ли пароль bcrypt-хешу
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+const (
+	// MaxPasswordAttempts - сколько неверных паролей к одной ссылке с одного адреса допускается за PasswordAttemptsWindow
+	MaxPasswordAttempts    = 5
+	PasswordAttemptsWindow = 15 * time.Minute
+)
+
+// AttemptLimiter ограничивает число проверок пароля к каждой ссылке с каждого адреса
+// за окно времени. Попытки считаются отдельно по адресам, чтобы подбор пароля
+// с чужого адреса не блокировал ссылку для её владельца. Попытка занимается до
+// проверки пароля, поэтому параллельные запросы не проходят сверх лимита, пока
+// идёт медленное сравнение bcrypt.
+type AttemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[attemptKey]*attempts
+}
+
+// attemptKey - ссылка и адрес клиента, попытки которого к ней учитываются
+type attemptKey struct {
+	link   string
+	client string
+}
+
+type attempts struct {
+	count int
+	start time.Time
+}
+
+// NewAttemptLimiter создает AttemptLimiter, допускающий max неверных паролей за window
+func NewAttemptLimiter(max int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[attemptKey]*attempts),
+	}
+}
+
+// Reserve занимает попытку клиента client проверить пароль к ссылке link; false
+// означает, что попытки за текущее окно исчерпаны. Если пароль оказался верным
+// или проверка не состоялась, попытку нужно вернуть через Release.
+func (l *AttemptLimiter) Reserve(link, client string, now time.Time) bool {
+	key := attemptKey{link: link, client: client}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok || now.Sub(a.start) >= l.window {
+		l.prune(now)
+		l.attempts[key] = &attempts{count: 1, start: now}
+		return true
+	}
+	if a.count >= l.max {
+		return false
+	}
+	a.count++
+	return true
+}
+
+// Release возвращает попытку, занятую Reserve для ссылки link и клиента client
+func (l *AttemptLimiter) Release(link, client string) {
+	key := attemptKey{link: link, client: client}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok {
+		return
+	}
+	a.count--
+	if a.count <= 0 {
+		delete(l.attempts, key)
+	}
+}
+
+// ErrTooManyAttempts возвращается Attempt, если попытки ввести пароль к ссылке исчерпаны
+var ErrTooManyAttempts = errors.New("too many wrong passwords, try again later")
+
+// Attempt выполняет переход клиента client по ссылке link с паролем через resolve,
+// учитывая попытку. Без пароля resolve вызывается без ограничений. Попытка
+// расходуется только на неверный пароль: при любом другом исходе она возвращается.
+func (l *AttemptLimiter) Attempt(link, client, password string, now time.Time, resolve func() error) error {
+	if password == "" {
+		return resolve()
+	}
+	if !l.Reserve(link, client, now) {
+		return ErrTooManyAttempts
+	}
+	err := resolve()
+	if !errors.Is(err, storageErrors.ErrWrongPassword) {
+		l.Release(link, client)
+	}
+	return err
+}
+
+// prune удаляет записи с истёкшим окном, чтобы карта не росла без ограничений
+func (l *AttemptLimiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.start) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/11Petrov/urlshortener/internal/models"
+)
+
+// Виды ошибок проверки параметров сокращения. Ошибки ShortenOptions оборачивают
+// один из них, и транспорт выбирает по нему код ответа через errors.Is.
+var (
+	ErrInvalidAlias      = errors.New("invalid alias")
+	ErrInvalidExpiration = errors.New("invalid expiration")
+	ErrInvalidMaxClicks  = errors.New("invalid max_clicks")
+	ErrInvalidPassword   = errors.New("invalid password")
+)
+
+// optionError - ошибка параметра вида kind; клиенту отдаётся текст err
+type optionError struct {
+	kind error
+	err  error
+}
+
+func (e *optionError) Error() string { return e.err.Error() }
+
+func (e *optionError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// ShortenParams - параметры сокращения в том виде, в каком их присылает клиент
+type ShortenParams struct {
+	Alias     string
+	ExpiresAt time.Time
+	TTL       string
+	MaxClicks int
+	Password  string
+}
+
+// ShortenOptions проверяет параметры сокращения и готовит их для хранилища.
+// HTTP и gRPC проверяют параметры только через неё, чтобы правила не расходились.
+func ShortenOptions(p ShortenParams, now time.Time) (models.ShortenOptions, error) {
+	if p.Alias != "" {
+		if err := ValidateAlias(p.Alias); err != nil {
+			return models.ShortenOptions{}, &optionError{ErrInvalidAlias, err}
+		}
+	}
+	expiresAt, err := ExpiresAt(p.ExpiresAt, p.TTL, now)
+	if err != nil {
+		return models.ShortenOptions{}, &optionError{ErrInvalidExpiration, err}
+	}
+	if p.MaxClicks < 0 {
+		return models.ShortenOptions{}, &optionError{ErrInvalidMaxClicks, errors.New("max_clicks must not be negative")}
+	}
+	opts := models.ShortenOptions{Alias: p.Alias, ExpiresAt: expiresAt, MaxClicks: p.MaxClicks}
+	if p.Password != "" {
+		if opts.PasswordHash, err = HashPassword(p.Password); err != nil {
+			return models.ShortenOptions{}, &optionError{ErrInvalidPassword, err}
+		}
+	}
+	return opts, nil
+}
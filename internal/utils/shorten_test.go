@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	storageErrors "github.com/11Petrov/urlshortener/internal/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShortenOptions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		params   ShortenParams
+		wantKind error
+	}{
+		{name: "empty", params: ShortenParams{}},
+		{name: "all set", params: ShortenParams{Alias: "my-link", TTL: "1h", MaxClicks: 3, Password: "secret"}},
+		{name: "bad alias", params: ShortenParams{Alias: "bad alias"}, wantKind: ErrInvalidAlias},
+		{name: "reserved alias", params: ShortenParams{Alias: "api"}, wantKind: ErrInvalidAlias},
+		{name: "past expiration", params: ShortenParams{ExpiresAt: now.Add(-time.Hour)}, wantKind: ErrInvalidExpiration},
+		{name: "expiration and ttl", params: ShortenParams{ExpiresAt: now.Add(time.Hour), TTL: "1h"}, wantKind: ErrInvalidExpiration},
+		{name: "negative max clicks", params: ShortenParams{MaxClicks: -1}, wantKind: ErrInvalidMaxClicks},
+		{name: "long password", params: ShortenParams{Password: strings.Repeat("x", MaxPasswordLength+1)}, wantKind: ErrInvalidPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := ShortenOptions(tt.params, now)
+			if tt.wantKind != nil {
+				assert.ErrorIs(t, err, tt.wantKind)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.params.Alias, opts.Alias)
+			assert.Equal(t, tt.params.MaxClicks, opts.MaxClicks)
+			if tt.params.TTL != "" {
+				assert.Equal(t, now.Add(time.Hour), opts.ExpiresAt)
+			}
+			if tt.params.Password != "" {
+				assert.True(t, CheckPassword(opts.PasswordHash, tt.params.Password))
+			}
+		})
+	}
+}
+
+func TestAttemptLimiterAttempt(t *testing.T) {
+	now := time.Now()
+	limiter := NewAttemptLimiter(2, time.Minute)
+	wrong := func() error { return storageErrors.ErrWrongPassword }
+	other := errors.New("storage is down")
+
+	// без пароля попытки не учитываются
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, limiter.Attempt("abc", "10.0.0.1", "", now, wrong), storageErrors.ErrWrongPassword)
+	}
+	// верный пароль и сбой хранилища попытку не расходуют
+	assert.NoError(t, limiter.Attempt("abc", "10.0.0.1", "secret", now, func() error { return nil }))
+	assert.ErrorIs(t, limiter.Attempt("abc", "10.0.0.1", "secret", now, func() error { return other }), other)
+
+	assert.ErrorIs(t, limiter.Attempt("abc", "10.0.0.1", "guess", now, wrong), storageErrors.ErrWrongPassword)
+	assert.ErrorIs(t, limiter.Attempt("abc", "10.0.0.1", "guess", now, wrong), storageErrors.ErrWrongPassword)
+	called := false
+	err := limiter.Attempt("abc", "10.0.0.1", "secret", now, func() error { called = true; return nil })
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	assert.False(t, called, "resolve must not run once attempts are exhausted")
+	// подбор пароля с чужого адреса не блокирует ссылку для других клиентов
+	assert.NoError(t, limiter.Attempt("abc", "10.0.0.2", "secret", now, func() error { return nil }))
+
+	assert.NoError(t, limiter.Attempt("abc", "10.0.0.1", "secret", now.Add(time.Minute), func() error { return nil }))
+}
@@ -4,12 +4,28 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"regexp"
+	"strconv"
 )
 
+// MaxShortURLAttempts - сколько вариантов короткого URL перебирается при коллизиях
+const MaxShortURLAttempts = 10
+
+var nonAlphanumeric = regexp.MustCompile("[^a-zA-Z0-9]+")
+
 func GenerateShortURL(url string) string {
-	hash := sha256.Sum256([]byte(url))
+	return GenerateShortURLAttempt(url, 0)
+}
+
+// GenerateShortURLAttempt возвращает вариант короткого URL для попытки attempt.
+// Нулевая попытка совпадает с GenerateShortURL; последующие хешируют URL с солью
+// и каждые две попытки удлиняют код на символ, чтобы уйти от занятого значения.
+func GenerateShortURLAttempt(url string, attempt int) string {
+	data := url
+	if attempt > 0 {
+		data = url + "#" + strconv.Itoa(attempt)
+	}
+	hash := sha256.Sum256([]byte(data))
 	shortURL := base64.URLEncoding.EncodeToString(hash[:])
-	regExp := regexp.MustCompile("[^a-zA-Z0-9]+")
-	shortURL = regExp.ReplaceAllString(shortURL, "")
-	return shortURL[:8]
+	shortURL = nonAlphanumeric.ReplaceAllString(shortURL, "")
+	return shortURL[:8+attempt/2]
 }
@@ -0,0 +1,5 @@
+// Package proto содержит описание gRPC API сервиса и сгенерированный по нему код.
+// Для перегенерации нужны buf, protoc-gen-go и protoc-gen-go-grpc.
+package proto
+
+//go:generate buf generate